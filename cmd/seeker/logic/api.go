@@ -86,7 +86,7 @@ func overrideStart(w http.ResponseWriter, req *http.Request) {
 		})
 		renderRedirect(w, fmt.Sprintf("Start overridden to %s", ts.String()))
 	} else {
-		renderRedirect(w, fmt.Sprintf("can't find entry with serial %s", serial))
+		renderRedirect(w, fmt.Sprintf("can't find entry with serial %d", serial))
 	}
 }
 
@@ -363,8 +363,6 @@ func (s *State) watcherMain(ctx context.Context) ([]position, error) {
 			positions = append(positions, position{offset, update.Time, update.Source})
 		}
 	}
-	logrus.Warn("end of seeker loop")
-	return positions, nil
 }
 
 type position struct {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/backup"
+	"github.com/crast/dvr-tools/internal/fileio"
+	"github.com/sirupsen/logrus"
+)
+
+// backupOriginal moves the original out of the way of a processed file with
+// the same name, into the backup dir if there is one.
+func backupOriginal(ctx context.Context, job *Job, fileName string) error {
+	if dir := job.Config.Backup.Dir; dir != "" {
+		store := &backup.Store{Dir: dir}
+		backupFile, err := store.Save(ctx, fileName)
+		if err != nil {
+			return err
+		}
+		job.Log.Infof("Backed up original to %s", backupFile)
+		pruneBackups(job, store, backupFile)
+		return nil
+	}
+	backupFile := filepath.Join(filepath.Dir(fileName), backup.LegacyPrefix+filepath.Base(fileName))
+	return os.Rename(fileName, backupFile)
+}
+
+// pruneBackups applies the retention limits to the backup dir after each
+// backup, so they hold without running prune-backups. The backup just made is
+// always kept.
+func pruneBackups(job *Job, store *backup.Store, keep string) {
+	bc := job.Config.Backup
+	if bc.MaxAgeDays <= 0 && bc.MaxSizeGB <= 0 {
+		return
+	}
+	removed, err := store.Prune(time.Duration(bc.MaxAgeDays)*24*time.Hour, int64(bc.MaxSizeGB)<<30, keep)
+	for _, e := range removed {
+		job.Log.Infof("Removed expired backup %s", e.Path)
+	}
+	if err != nil {
+		job.Log.Warnf("could not prune backups: %s", err.Error())
+	}
+}
+
+func runPruneBackups(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	fs := flag.NewFlagSet("prune-backups", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only list the backups that would be removed")
+	maxAgeDays := fs.Int("max-age-days", conf.Backup.MaxAgeDays, "Remove backups older than this")
+	maxSizeGB := fs.Int("max-size-gb", conf.Backup.MaxSizeGB, "Remove the oldest backups until they total at most this")
+	fs.Parse(args)
+	if *maxAgeDays <= 0 && *maxSizeGB <= 0 {
+		return fmt.Errorf("no retention limit, set [backup] max-age-days or max-size-gb")
+	}
+
+	// Old style backups are found in the media dirs given, or the watched ones.
+	legacyDirs := fs.Args()
+	if len(legacyDirs) == 0 {
+		legacyDirs = conf.Watch.Dirs
+	}
+	entries, err := backup.LegacyBackups(legacyDirs)
+	if err != nil {
+		return err
+	}
+	if conf.Backup.Dir != "" {
+		stored, err := (&backup.Store{Dir: conf.Backup.Dir}).List()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, stored...)
+	}
+
+	maxAge := time.Duration(*maxAgeDays) * 24 * time.Hour
+	maxSize := int64(*maxSizeGB) << 30
+	var freed int64
+	expired := backup.Expired(entries, maxAge, maxSize, time.Now())
+	for _, e := range expired {
+		if *dryRun {
+			fmt.Printf("would remove %s (%s, backed up %s)\n", e.Path, formatBytes(e.Size), e.Saved.Format("2006-01-02"))
+			continue
+		}
+		if err := os.Remove(e.Path); err != nil {
+			logrus.Warnf("could not remove backup %s: %s", e.Path, err.Error())
+			continue
+		}
+		logrus.Infof("removed backup %s", e.Path)
+		freed += e.Size
+	}
+	if !*dryRun {
+		fmt.Printf("removed %d of %d backups, freed %s\n", len(expired), len(entries), formatBytes(freed))
+	}
+	return nil
+}
+
+func runRestoreBackup(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	fs := flag.NewFlagSet("restore-backup", flag.ExitOnError)
+	overwrite := fs.Bool("overwrite", false, "Replace a file already at the original's path")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: videoproc restore-backup [-overwrite] <files>")
+	}
+
+	store := &backup.Store{Dir: conf.Backup.Dir}
+	for _, name := range fs.Args() {
+		var e *backup.Entry
+		var err error
+		if legacy := filepath.Join(filepath.Dir(name), backup.LegacyPrefix+filepath.Base(name)); fileio.IsFile(legacy) {
+			e = &backup.Entry{Path: legacy, Original: name}
+		} else if conf.Backup.Dir != "" {
+			e, err = store.Find(name)
+		} else {
+			err = fmt.Errorf("no backup of %s", name)
+		}
+		if err == nil {
+			err = store.Restore(ctx, e, *overwrite)
+		}
+		if err != nil {
+			return err
+		}
+		logrus.Infof("restored %s from %s", e.Original, e.Path)
+	}
+	return nil
+}
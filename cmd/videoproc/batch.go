@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/sirupsen/logrus"
+)
+
+var defaultMediaExtensions = []string{".ts", ".mkv", ".mp4", ".m2ts", ".mpg"}
+
+// Originals kept by processVideo are never worth processing again.
+var defaultExcludes = []string{"backup.orig.*"}
+
+func runBatch(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	bc := conf.Batch
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	workers := fs.Int("jobs", bc.Workers, "Number of files to process at once")
+	comskipJobs := fs.Int("comskip-jobs", bc.ComskipLimit, "Max comskip runs at once (0 for no extra limit)")
+	ffmpegJobs := fs.Int("ffmpeg-jobs", bc.FFmpegLimit, "Max ffmpeg runs at once (0 for no extra limit)")
+	include := append(stringList{}, bc.Include...)
+	exclude := append(stringList{}, bc.Exclude...)
+	fs.Var(&include, "include", "Only process files matching this glob (repeatable)")
+	fs.Var(&exclude, "exclude", "Skip files matching this glob (repeatable)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("batch: no dirs, files or globs given")
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+	extensions := bc.Extensions
+	if len(extensions) == 0 {
+		extensions = defaultMediaExtensions
+	}
+
+	files, err := discoverMedia(fs.Args(), extensions, include, append(exclude, defaultExcludes...))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("batch: %d files to process with %d workers", len(files), *workers)
+
+	limitTool("comskip", *comskipJobs)
+	limitTool("ffmpeg", *ffmpegJobs)
+
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, fileName := range files {
+			select {
+			case queue <- fileName:
+			case <-dispatchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	results := map[string]jobResult{}
+	runWorkers(ctx, conf, *workers, queue, func(r jobResult) {
+		mu.Lock()
+		results[r.File] = r
+		mu.Unlock()
+	})
+
+	summary := make([]jobResult, len(files))
+	failed := 0
+	for i, fileName := range files {
+		r, ok := results[fileName]
+		if !ok {
+			r = jobResult{File: fileName, Status: statusNotStarted}
+		}
+		if r.Status == statusFailed {
+			failed++
+		}
+		summary[i] = r
+	}
+	printSummary(summary)
+
+	if failed != 0 {
+		return fmt.Errorf("batch: %d of %d files failed", failed, len(files))
+	}
+	return dispatchCtx.Err()
+}
+
+const (
+	statusProcessed  = "processed"
+	statusSkipped    = "skipped"
+	statusFailed     = "failed"
+	statusNotStarted = "not started"
+)
+
+type jobResult struct {
+	File     string
+	Status   string
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// runWorkers processes every file received on queue with the given number of
+// concurrent workers, reporting each result as it finishes. It returns once
+// queue is closed and all workers are done.
+func runWorkers(ctx context.Context, conf *videoproc.Config, workers int, queue <-chan string, report func(jobResult)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileName := range queue {
+				r := processFile(ctx, conf, fileName, jobOptions{})
+				if isHeld(r.Err) {
+					holdForReview(conf, fileName, r.Err)
+				}
+				report(r)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func processFile(ctx context.Context, conf *videoproc.Config, fileName string, opts jobOptions) jobResult {
+	begin := time.Now()
+	job, err := runJob(ctx, conf, fileName, opts)
+	r := jobResult{File: fileName, Status: statusProcessed, Duration: time.Since(begin)}
+	if err != nil {
+		r.Status = statusFailed
+		r.Detail = err.Error()
+		r.Err = err
+	} else if job.Skipped != "" {
+		r.Status = statusSkipped
+		r.Detail = job.Skipped
+	}
+	return r
+}
+
+func printSummary(results []jobResult) {
+	counts := map[string]int{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tTIME\tFILE\tDETAIL")
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Status, r.Duration.Round(time.Second), r.File, r.Detail)
+	}
+	w.Flush()
+	fmt.Printf("%d processed, %d skipped, %d failed, %d not started\n",
+		counts[statusProcessed], counts[statusSkipped], counts[statusFailed], counts[statusNotStarted])
+}
+
+// discoverMedia expands args (files, directories and globs) into a list of
+// media files. Directories are walked recursively and only files with one of
+// the given extensions are picked up from them.
+func discoverMedia(args []string, extensions, include, exclude []string) ([]string, error) {
+	var files []string
+	// Files that would be processed into the same mkv, such as show.ts and
+	// show.mkv, are only taken once, preferring the recording.
+	byDest := map[string]int{}
+	add := func(fileName string) {
+		if !matchesFilters(fileName, include, exclude) {
+			return
+		}
+		dest := stripExtension(fileName) + ".mkv"
+		if i, ok := byDest[dest]; ok {
+			if files[i] == dest && fileName != dest {
+				files[i] = fileName
+			}
+			return
+		}
+		byDest[dest] = len(files)
+		files = append(files, fileName)
+	}
+
+	for _, arg := range args {
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad glob %s: %s", arg, err.Error())
+			}
+			paths = matches
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			err = filepath.Walk(path, func(fileName string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() && hasExtension(fileName, extensions) {
+					add(fileName)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+func matchesFilters(fileName string, include, exclude []string) bool {
+	if len(include) != 0 && !matchesAny(fileName, include) {
+		return false
+	}
+	return !matchesAny(fileName, exclude)
+}
+
+func matchesAny(fileName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(fileName)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, fileName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(fileName string, extensions []string) bool {
+	ext := filepath.Ext(fileName)
+	for _, e := range extensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
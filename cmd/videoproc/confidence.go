@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/comskip"
+)
+
+// weighCommercials splits commercials into the ones comskip scored high
+// enough to cut and the ones to only mark with chapters. cut is false when
+// too little of the commercial time can be cut, and chapter mode should be
+// used for all of them instead. Commercials comskip has no score for, such
+// as ones from a sidecar, are always cut.
+func weighCommercials(job *Job, decision *videoproc.Rule, commercials []Commercial) (sure, unsure []Commercial, cut bool) {
+	if decision.ComskipCutScore == 0 && decision.ComskipMinConfidence == 0 {
+		return commercials, nil, true
+	}
+	if len(job.comskipBlocks) == 0 {
+		job.Log.Warn("No comskip scores to weigh commercials with, cutting them all")
+		return commercials, nil, true
+	}
+	threshold := decision.ComskipCutScore
+	if threshold == 0 {
+		threshold = comskip.DefaultThreshold
+	}
+
+	var total, sureTotal float64
+	for _, c := range commercials {
+		total += c.Duration()
+		score, ok := comskip.Score(job.comskipBlocks, c)
+		if !ok || score >= threshold {
+			sure = append(sure, c)
+			sureTotal += c.Duration()
+		} else {
+			unsure = append(unsure, c)
+		}
+		if ok {
+			job.Log.Infof("Commercial %s-%s scored %.2f", timestampMKV(c.Begin), timestampMKV(c.End), score)
+		}
+	}
+	confidence := sureTotal / total
+	job.Log.Infof("%d of %d commercials clear score %.2f, confidence %.0f%%", len(sure), len(commercials), threshold, confidence*100)
+	if len(sure) == 0 || confidence < decision.ComskipMinConfidence {
+		return nil, nil, false
+	}
+	return sure, unsure, true
+}
@@ -2,22 +2,43 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 )
 
+// stdin is shared by the prompts, so input read ahead by one isn't lost to
+// the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+// checkPrompt makes sure prompts can be answered for the command in args:
+// one job at a time, with someone at a terminal. Daemons and worker pools
+// would otherwise wait on, or die on, input nobody can give.
+func checkPrompt(args []string) error {
+	switch args[0] {
+	case "batch", "watch", "serve":
+		return fmt.Errorf("--prompt-wl can't be used with %s", args[0])
+	case "queue":
+		if len(args) > 1 && args[1] == "run" {
+			return errors.New("--prompt-wl can't be used with queue run")
+		}
+	}
+	if !isTerminal(os.Stdin) {
+		return errors.New("--prompt-wl needs a terminal on stdin")
+	}
+	return nil
+}
+
 // confirm displays a prompt `s` to the user and returns a bool indicating yes / no
 // If the lowercased, trimmed input begins with anything other than 'y', it returns false
 // It accepts an int `tries` representing the number of attempts before returning false
 func confirm(s string, tries int) bool {
-	r := bufio.NewReader(os.Stdin)
-
 	for ; tries > 0; tries-- {
 		fmt.Printf("%s [y/n]: ", s)
 
-		res, err := r.ReadString('\n')
+		res, err := stdin.ReadString('\n')
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -34,13 +55,11 @@ func confirm(s string, tries int) bool {
 }
 
 func makeChoice(prompt string, choices []string, tries int) (bool, string) {
-	r := bufio.NewReader(os.Stdin)
-
 	sc := strings.Join(choices, "/")
 	for ; tries > 0; tries-- {
-		fmt.Printf("%s [$s]: ", prompt, sc)
+		fmt.Printf("%s [%s]: ", prompt, sc)
 
-		res, err := r.ReadString('\n')
+		res, err := stdin.ReadString('\n')
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -59,3 +78,14 @@ func makeChoice(prompt string, choices []string, tries int) (bool, string) {
 
 	return false, ""
 }
+
+// readLine displays a prompt `s` and returns the trimmed line typed in reply
+func readLine(s string) string {
+	fmt.Printf("%s: ", s)
+
+	res, err := stdin.ReadString('\n')
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.TrimSpace(res)
+}
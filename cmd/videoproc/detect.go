@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/comskip"
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/pkg/errors"
+)
+
+// A Detector is a source of commercial breaks for a recording.
+type Detector interface {
+	// Detect returns the commercials in fileName. It returns nil, rather
+	// than an empty list, when it has nothing to go on for this file (say,
+	// there is no watchlog), so it's left out of votes.
+	Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error)
+}
+
+func newDetector(name string, decision *videoproc.Rule, c videoproc.EvalCtx) (Detector, error) {
+	switch name {
+	case "comskip":
+		return comskipDetector{decision, c.Video.FrameRate}, nil
+	case "chapters":
+		return chaptersDetector{}, nil
+	case "watchlog":
+		return watchlogDetector{c.DurationSec}, nil
+	case "sidecar", "edl":
+		return sidecarDetector{c.Video.FrameRate}, nil
+	case "manual":
+		if cutList == "" {
+			return nil, fmt.Errorf("manual detector needs --cut")
+		}
+		return manualDetector{cutList, c.Video.FrameRate, c.DurationSec}, nil
+	case "script":
+		if decision.DetectorScript == "" {
+			return nil, fmt.Errorf("script detector needs a detector-script")
+		}
+		return scriptDetector{decision.DetectorScript, c.DurationSec}, nil
+	default:
+		return nil, fmt.Errorf("unknown detector %s", name)
+	}
+}
+
+// detectCommercials runs the decision's detectors and merges what they find.
+// Without any set, a cut list next to the file is used or else comskip, or
+// with --existing-chapters the file's chapters and then its watchlog. Cuts
+// given with --cut replace them all.
+func detectCommercials(ctx context.Context, job *Job, fileName string, decision *videoproc.Rule, c videoproc.EvalCtx) ([]Commercial, error) {
+	names, strategy := decision.Detectors, decision.DetectorMerge
+	if cutList != "" {
+		names, strategy = []string{"manual"}, cutlist.FirstNonEmpty
+	} else if len(names) == 0 {
+		names, strategy = defaultDetectors(ctx, job, fileName), cutlist.FirstNonEmpty
+	}
+	lazy := (strategy == "" || strategy == cutlist.FirstNonEmpty) && !promptWatchlog
+
+	var lists [][]Commercial
+	for _, name := range names {
+		d, err := newDetector(name, decision, c)
+		if err != nil {
+			return nil, err
+		}
+		found, err := d.Detect(ctx, job, fileName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s detector", name)
+		}
+		if found == nil {
+			job.Log.Infof("%s detector had nothing to go on", name)
+		} else {
+			job.Log.Infof("%s detector found %d commercials", name, len(found))
+		}
+		lists = append(lists, found)
+		if lazy && len(found) != 0 {
+			break
+		}
+	}
+	if promptWatchlog {
+		return promptDetections(ctx, job, fileName, c.DurationSec, names, lists)
+	}
+	return cutlist.Merge(strategy, lists)
+}
+
+func defaultDetectors(ctx context.Context, job *Job, fileName string) []string {
+	if !useExistingChapters {
+		return []string{"sidecar", "comskip"}
+	}
+	prefer := preferWatchlog
+	if wlDir := job.Config.General.WatchLogDir; wlDir != "" && !prefer {
+		if wl, err := getWatchLogIfExists(ctx, wlDir, fileName); err == nil && wl != nil && wl.Note == "prefer" {
+			prefer = true
+		}
+	}
+	if prefer {
+		job.Log.Info("Preferring watchlog chapters")
+		return []string{"watchlog", "chapters"}
+	}
+	return []string{"chapters", "watchlog"}
+}
+
+// comskipDetector runs comskip, and keeps the scores it gave each block on
+// the job for weighCommercials.
+type comskipDetector struct {
+	decision *videoproc.Rule
+	fps      float64
+}
+
+func (d comskipDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	commercials, err := runComskip(ctx, job, fileName, d.decision)
+	if err != nil {
+		return nil, err
+	}
+	if commercials == nil {
+		return []Commercial{}, nil
+	}
+	if f, err := os.Open(job.ScratchFile("comskip.log")); err == nil {
+		job.comskipBlocks, err = comskip.ParseLog(f, d.fps)
+		f.Close()
+		if err != nil {
+			job.Log.Debugf("No comskip scores: %s", err)
+		}
+	}
+	return commercials, nil
+}
+
+// chaptersDetector reads commercials from chapters already in the file, as
+// written by an earlier run in chapter mode.
+type chaptersDetector struct{}
+
+func (chaptersDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	chapters, err := extractExistingChapters(ctx, job, fileName)
+	if err != nil || len(chapters) == 0 {
+		return nil, err
+	}
+	commercials := []Commercial{}
+	for _, c := range chapters {
+		if c.IsCommercial {
+			commercials = append(commercials, Commercial{Begin: c.Begin, End: c.End})
+		}
+	}
+	return commercials, nil
+}
+
+// watchlogDetector treats what the viewer skipped over as the commercials.
+type watchlogDetector struct {
+	duration float64
+}
+
+func (d watchlogDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	wlDir := job.Config.General.WatchLogDir
+	if wlDir == "" {
+		return nil, nil
+	}
+	wl, err := getWatchLogIfExists(ctx, wlDir, fileName)
+	if err != nil || wl == nil {
+		return nil, err
+	}
+	var watched []cutlist.Range
+	for _, c := range watchLogChapters(wl) {
+		watched = append(watched, cutlist.Range{Begin: c.Begin, End: c.End})
+	}
+	if len(watched) == 0 {
+		return nil, nil
+	}
+	return cutlist.Invert(watched, d.duration), nil
+}
+
+// sidecarDetector reads a cut list left next to the recording by another
+// tool, such as a Kodi EDL, comskip .txt, MythTV cutlist or VideoReDo project.
+type sidecarDetector struct {
+	fps float64
+}
+
+func (d sidecarDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	path, format := cutlist.FindSidecar(stripExtension(fileName))
+	if path == "" {
+		return nil, nil
+	}
+	commercials, err := cutlist.ReadFile(path, format, d.fps)
+	if errors.Cause(err) == cutlist.ErrNotCutList {
+		job.Log.Debugf("%s is not a cut list", path)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job.Log.Infof("Using %s cut list %s", format, path)
+	if commercials == nil {
+		commercials = []Commercial{}
+	}
+	return commercials, nil
+}
+
+// scriptDetector runs an external program, which is given the recording's
+// path as its argument and a scriptRequest on stdin, and answers with a
+// scriptResponse on stdout.
+type scriptDetector struct {
+	script   string
+	duration float64
+}
+
+type scriptRequest struct {
+	File       string  `json:"file"`
+	Duration   float64 `json:"duration"`
+	ScratchDir string  `json:"scratchDir"`
+}
+
+// scriptResponse lists the commercials found. Leaving out commercials, or
+// setting it to null, means the script had nothing to go on.
+type scriptResponse struct {
+	Commercials []struct {
+		Begin float64 `json:"begin"`
+		End   float64 `json:"end"`
+	} `json:"commercials"`
+}
+
+func (d scriptDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	req, _ := json.Marshal(scriptRequest{fileName, d.duration, job.ScratchDir()})
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.script, fileName)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if job.output != nil {
+		cmd.Stderr = job.output
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "run %s", d.script)
+	}
+	var resp scriptResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, errors.Wrapf(err, "bad output from %s", d.script)
+	}
+	if resp.Commercials == nil {
+		return nil, nil
+	}
+	commercials := []Commercial{}
+	for _, c := range resp.Commercials {
+		commercials = append(commercials, Commercial{Begin: c.Begin, End: c.End})
+	}
+	return commercials, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/mediainfo"
+	"github.com/crast/dvr-tools/watchlog"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// existingEDL is the INI reported for commercials read from an EDL already
+// next to a recording, as which INI made it isn't known. Such an EDL is only
+// used when the rule doesn't name an INI to run comskip with.
+const existingEDL = "(existing edl)"
+
+// defaultINI is the INI reported when comskip was run without one, and used
+// its own default.
+const defaultINI = "(comskip default)"
+
+func runEvaluate(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	fs := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	ini := fs.String("comskip-ini", "", "Run comskip with this INI instead of the rule's")
+	rerun := fs.Bool("rerun", false, "Run comskip even when the rule has no INI and there's an EDL next to the recording")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("evaluate: no dirs, files or globs given")
+	}
+	if conf.General.WatchLogDir == "" {
+		return fmt.Errorf("evaluate: needs a watch-log-dir to compare with")
+	}
+	extensions := conf.Batch.Extensions
+	if len(extensions) == 0 {
+		extensions = defaultMediaExtensions
+	}
+	files, err := discoverMedia(fs.Args(), extensions, nil, defaultExcludes)
+	if err != nil {
+		return err
+	}
+
+	byShow := map[string]*cutlist.Accuracy{}
+	byINI := map[string]*cutlist.Accuracy{}
+	var total cutlist.Accuracy
+	add := func(m map[string]*cutlist.Accuracy, key string, a cutlist.Accuracy) {
+		if m[key] == nil {
+			m[key] = &cutlist.Accuracy{}
+		}
+		m[key].Add(a)
+	}
+	evaluated := 0
+	for _, fileName := range files {
+		if dispatchCtx.Err() != nil {
+			break
+		}
+		a, usedINI, ok, err := evaluateFile(ctx, conf, fileName, *ini, *rerun)
+		if err != nil {
+			logrus.Errorf("could not evaluate %s: %s", fileName, err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+		add(byShow, showName(fileName), a)
+		add(byINI, usedINI, a)
+		total.Add(a)
+		evaluated++
+	}
+	if evaluated == 0 {
+		return fmt.Errorf("evaluate: no recordings with a usable watchlog")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	printAccuracy(w, "Show", byShow)
+	fmt.Fprintln(w)
+	printAccuracy(w, "Comskip INI", byINI)
+	fmt.Fprintln(w)
+	printAccuracy(w, "", map[string]*cutlist.Accuracy{fmt.Sprintf("All recordings (%d)", evaluated): &total})
+	return w.Flush()
+}
+
+// evaluateFile measures comskip's commercials for a recording against its
+// watchlog, and which INI found them. ok is false when the recording has no
+// watchlog to go on.
+func evaluateFile(ctx context.Context, conf *videoproc.Config, fileName, ini string, rerun bool) (a cutlist.Accuracy, usedINI string, ok bool, err error) {
+	var none cutlist.Accuracy
+	wl, err := getWatchLogIfExists(ctx, conf.General.WatchLogDir, fileName)
+	if err != nil || wl == nil {
+		return none, "", false, err
+	}
+	if wl.Note == "partial" {
+		logrus.Infof("Skipping %s, which wasn't watched through", fileName)
+		return none, "", false, nil
+	}
+	skips, consec := wl.Skips, wl.Consec
+	if len(skips) == 0 && len(consec) == 0 {
+		skips, consec = watchlog.DetectSkips(watchlog.BasicTape(wl.Tape))
+	}
+	if len(skips) == 0 {
+		// A viewer who skipped nothing says nothing about commercials.
+		logrus.Infof("Skipping %s, whose watchlog has no skips", fileName)
+		return none, "", false, nil
+	}
+
+	info, err := mediainfo.Parse(ctx, fileName)
+	if err != nil {
+		return none, "", false, errors.Wrap(err, "could not parse mediainfo")
+	}
+	c, _, _ := buildEvalCtx(info, fileName)
+
+	evaluators, err := videoproc.MakeEvaluators(conf.Rule)
+	if err != nil {
+		return none, "", false, errors.Wrap(err, "could not build evaluator")
+	}
+	decision, _, err := makeDecision(conf, evaluators, c, nil)
+	if err != nil {
+		return none, "", false, err
+	}
+	if ini != "" {
+		decision.ComskipINI = ini
+	}
+
+	var found []Commercial
+	usedINI = existingEDL
+	edl := stripExtension(fileName) + ".edl"
+	// An EDL left by a DVR or an earlier comskip run can't say which INI
+	// made it, so it's only scored when there's no INI to score instead.
+	if _, err := os.Stat(edl); err == nil && !rerun && decision.ComskipINI == "" {
+		if found, err = cutlist.ReadFile(edl, cutlist.EDL, c.Video.FrameRate); err != nil {
+			return none, "", false, err
+		}
+	} else {
+		job := NewJob(conf, fileName)
+		if err := job.makeScratchDir(conf.General.ScratchDir); err != nil {
+			return none, "", false, err
+		}
+		found, err = runComskip(ctx, job, fileName, decision)
+		job.DeleteFiles()
+		if err != nil {
+			return none, "", false, err
+		}
+		usedINI = decision.ComskipINI
+		if usedINI == "" {
+			usedINI = defaultINI
+		}
+	}
+
+	a = cutlist.Measure(found, regionRanges(skips), regionRanges(watchlog.FilterConsec(consec)))
+	logrus.Infof("%s: precision %.2f, recall %.2f, boundary error %.1fs",
+		filepath.Base(fileName), a.Precision(), a.Recall(), a.MeanBoundaryError())
+	return a, usedINI, true, nil
+}
+
+func regionRanges(regions []watchlog.Region) []cutlist.Range {
+	var out []cutlist.Range
+	for _, r := range regions {
+		out = append(out, cutlist.Range{Begin: r.Begin.Float(), End: r.End.Float()})
+	}
+	return out
+}
+
+var seasonDir = regexp.MustCompile(`(?i)^(season|series)\s*\d+$`)
+
+// showName guesses a recording's show from its name, as in
+// "Show - S01E02 - Title.ts", or else the directory it's in.
+func showName(fileName string) string {
+	base := filepath.Base(stripExtension(fileName))
+	if i := strings.Index(base, " - "); i > 0 {
+		return base[:i]
+	}
+	dir := filepath.Dir(fileName)
+	if seasonDir.MatchString(filepath.Base(dir)) {
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Base(dir)
+}
+
+func printAccuracy(w *tabwriter.Writer, heading string, m map[string]*cutlist.Accuracy) {
+	fmt.Fprintf(w, "%s\tPrecision\tRecall\tBoundary error\tCommercial time\n", heading)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a := m[k]
+		fmt.Fprintf(w, "%s\t%.1f%%\t%.1f%%\t%.1fs\t%s\n",
+			k, a.Precision()*100, a.Recall()*100, a.MeanBoundaryError(), timestampMKV(a.Truth))
+	}
+}
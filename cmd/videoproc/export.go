@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/pkg/errors"
+)
+
+// writeExports writes chapters next to finalFile in each of the given
+// sidecar formats, so players can skip commercials without the file being
+// cut.
+func writeExports(job *Job, finalFile string, chapters []Chapter, formats []string) error {
+	if len(chapters) == 0 {
+		return nil
+	}
+	base := stripExtension(finalFile)
+	done := map[string]bool{}
+	for _, format := range formats {
+		if done[format] {
+			continue
+		}
+		done[format] = true
+		ext, err := cutlist.ExportExt(format)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := cutlist.Export(&buf, format, chapters); err != nil {
+			return errors.Wrapf(err, "export %s", format)
+		}
+		if err := ioutil.WriteFile(base+ext, buf.Bytes(), 0666); err != nil {
+			return errors.Wrapf(err, "export %s", format)
+		}
+		job.Log.Infof("Wrote %s", base+ext)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var errFileLocked = errors.New("file is being processed by another job")
+
+// Lockfiles are pid based, so they don't stop two jobs in this process from
+// taking the same file. heldFiles covers that case.
+var (
+	heldMu    sync.Mutex
+	heldFiles = map[string]bool{}
+)
+
+func mediaLockName(fileName string) (string, error) {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(abs), "."+filepath.Base(abs)+".videoproc.lock"), nil
+}
+
+// lockMediaFile marks fileName as being processed, across processes too.
+// It returns errFileLocked if another job already holds it. A lock that
+// can't be created at all (e.g. a read-only media dir) is only a warning.
+func lockMediaFile(fileName string) (func(), error) {
+	name, err := mediaLockName(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	if heldFiles[name] {
+		return nil, errFileLocked
+	}
+
+	l, err := lockfile.New(name)
+	if err == nil {
+		err = l.TryLock()
+	}
+	if err == lockfile.ErrBusy {
+		return nil, errFileLocked
+	} else if err != nil {
+		logrus.Warnf("could not lock %s, continuing without a lock: %s", fileName, err.Error())
+		return func() {}, nil
+	}
+
+	heldFiles[name] = true
+	return func() {
+		heldMu.Lock()
+		delete(heldFiles, name)
+		heldMu.Unlock()
+		if err := l.Unlock(); err != nil {
+			logrus.Warnf("could not unlock %s: %s", fileName, err.Error())
+		}
+	}, nil
+}
+
+// mediaFileLocked reports whether some job currently holds fileName's lock.
+func mediaFileLocked(fileName string) bool {
+	name, err := mediaLockName(fileName)
+	if err != nil {
+		return false
+	}
+	heldMu.Lock()
+	held := heldFiles[name]
+	heldMu.Unlock()
+	if held {
+		return true
+	}
+	proc, err := lockfile.Lockfile(name).GetOwner()
+	return err == nil && proc.Pid != os.Getpid()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+)
+
+// toolLimits caps how many copies of an external program this process runs
+// at once. It is set up before any jobs start and only read afterwards.
+var toolLimits = map[string]chan struct{}{}
+
+func limitTool(prog string, n int) {
+	if n > 0 {
+		toolLimits[prog] = make(chan struct{}, n)
+	}
+}
+
+// acquireTool waits for a slot to run prog. The returned func releases it.
+func acquireTool(ctx context.Context, prog string) (func(), error) {
+	sem := toolLimits[prog]
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -16,15 +15,21 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/comskip"
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/ffcmd"
 	"github.com/crast/dvr-tools/internal/fileio"
+	"github.com/crast/dvr-tools/internal/keyframes"
+	"github.com/crast/dvr-tools/internal/scratch"
+	"github.com/crast/dvr-tools/internal/slots"
 	"github.com/crast/dvr-tools/mediainfo"
 	"github.com/crast/dvr-tools/watchlog"
 
-	"github.com/nightlyone/lockfile"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -32,13 +37,16 @@ import (
 const FLAG_VER = "7"
 
 var configFile string
-var scratchDir string
 var deleteOriginal bool
 var useExistingChapters bool
 var slapChop bool
+var smartCut bool
 var fuzzBegin float64
 var fuzzEnd float64
 var manualChop string
+var cutList string
+var forceProcess bool
+var ignoreLimits bool
 
 var (
 	debugMode      bool
@@ -54,18 +62,27 @@ func main() {
 			defaultConfigFile = tomlFile
 		}
 	}
-	var lockFile string
+	var lockFile, lockDir string
+	var lockSlots int
+	var lockTimeout time.Duration
 	flag.BoolVar(&debugMode, "debug", false, "Debug Mode")
 	flag.StringVar(&configFile, "config", defaultConfigFile, "TOML config file")
 	flag.BoolVar(&deleteOriginal, "delete-orig", false, "Delete original file")
 	flag.BoolVar(&useExistingChapters, "existing-chapters", false, "Use existing chapters if possible")
-	flag.StringVar(&lockFile, "lock-file", "", "Lock on this file")
+	flag.StringVar(&lockFile, "lock-file", "", "Deprecated, use --lock-dir")
+	flag.StringVar(&lockDir, "lock-dir", "", "Wait for a free slot in this dir before processing")
+	flag.IntVar(&lockSlots, "lock-slots", 0, "Number of files processed at once with --lock-dir (default 1)")
+	flag.DurationVar(&lockTimeout, "lock-timeout", 0, "How long to wait for a slot before queueing the file (default 1h)")
 	flag.BoolVar(&promptWatchlog, "prompt-wl", false, "Prompt for watchlog decision")
 	flag.BoolVar(&preferWatchlog, "prefer-watchlog", false, "Prefer watchlog")
 	flag.BoolVar(&slapChop, "chop-files", false, "Chop files")
-	flag.Float64Var(&fuzzBegin, "fuzz-begin", 0.000, "Chapter fuzz")
-	flag.Float64Var(&fuzzEnd, "fuzz-end", 0.000, "Chapter fuzz")
-	flag.StringVar(&manualChop, "manual-chop", "", "Force Chop string e.g. '0:45 0:78'")
+	flag.BoolVar(&smartCut, "smart-cut", false, "Chop files, re-encoding only around the cuts")
+	flag.Float64Var(&fuzzBegin, "fuzz-begin", 0.000, "Deprecated: padding-begin for rules without one")
+	flag.Float64Var(&fuzzEnd, "fuzz-end", 0.000, "Deprecated: padding-end for rules without one")
+	flag.StringVar(&manualChop, "manual-chop", "", "Deprecated, use --cut. Keep only between two times e.g. '0:45 58:00'")
+	flag.StringVar(&cutList, "cut", "", "Commercials to cut, e.g. '0:00-0:45,12:30-15:10,58:00-end', or a file of them")
+	flag.BoolVar(&forceProcess, "force", false, "Process even if the file is already up to date")
+	flag.BoolVar(&ignoreLimits, "ignore-limits", false, "Cut even when the commercials break the rule's limits")
 	flag.Parse()
 	if debugMode {
 		logrus.SetLevel(logrus.DebugLevel)
@@ -73,14 +90,33 @@ func main() {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
 
-	if flag.NArg() != 1 {
-		fmt.Println("usage: Blah De Blah <media file>")
-		flag.Usage()
-		os.Exit(1)
+	if flag.NArg() < 1 {
+		usage()
 	}
 
-	if manualChop != "" && slapChop {
-		logrus.Fatal("Cannot use manual chop and chop-files at the same time")
+	if smartCut {
+		slapChop = true
+	}
+	if manualChop != "" {
+		if cutList != "" {
+			logrus.Fatal("Cannot use --manual-chop and --cut at the same time")
+		}
+		var err error
+		if cutList, err = manualChopCuts(manualChop); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(cutList); cutList != "" && err != nil {
+		// Not a file, so check the cuts now rather than per file.
+		if _, err := cutlist.ParseCuts(cutList); err != nil {
+			logrus.Fatalf("bad --cut: %s", err)
+		}
+	}
+
+	if promptWatchlog {
+		if err := checkPrompt(flag.Args()); err != nil {
+			logrus.Fatal(err)
+		}
 	}
 
 	conf, err := videoproc.ParseConfig(configFile)
@@ -88,242 +124,412 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	fileio.DefaultOptions.Verify = conf.General.VerifyCopies
+	sweepScratch(conf)
+
+	ctx, dispatchCtx, cancel := signalContexts()
 	defer cancel()
 
-	go func() {
-		ch := make(chan os.Signal, 2)
-		signal.Notify(ch, os.Interrupt, syscall.SIGQUIT)
-		for _ = range ch {
-			cancel()
+	if command, ok := subcommands[flag.Arg(0)]; ok {
+		if err := command(ctx, dispatchCtx, conf, flag.Args()[1:]); err != nil {
+			logrus.Fatal(err)
 		}
-	}()
+		return
+	}
 
-	if lockFile != "" {
-		l, err := lockfile.New(lockFile)
-		if err != nil {
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	fileName := flag.Args()[0]
+
+	if lockFile != "" && lockDir == "" {
+		logrus.Warn("--lock-file is deprecated, use --lock-dir")
+		lockDir = lockFile + ".slots"
+	}
+	release, err := waitForSlot(dispatchCtx, conf, lockDir, lockSlots, lockTimeout)
+	if err == slots.ErrTimeout {
+		logrus.Warnf("p%d gave up waiting for a slot after %s", os.Getpid(), lockTimeout)
+		if !enqueueForRetry(conf, fileName, 0, err) {
 			logrus.Fatal(err)
-			return
 		}
-		duration := 1 * time.Second
-		begin := time.Now()
-		for {
-			if err := l.TryLock(); err == nil {
-				defer l.Unlock()
-				break
-			}
+		return
+	} else if err != nil {
+		logrus.Fatal(err)
+	}
+	defer release()
 
-			if time.Since(begin) > 1*time.Hour {
-				logrus.Warnf("p%d giving up, waited over 1 hr", os.Getpid())
-				os.Exit(1)
-			}
+	// A single file has nothing to drain, so the first interrupt cancels it.
+	if _, err := runJob(dispatchCtx, conf, fileName, jobOptions{}); err != nil {
+		if isHeld(err) && holdForReview(conf, fileName, err) {
+			return
+		}
+		if dispatchCtx.Err() == nil && isTransient(err) {
+			// An interrupt also kills the tools, which isn't worth a retry.
+			enqueueForRetry(conf, fileName, retryDelay(conf.Queue, 1), err)
+		}
+		logrus.Fatal(err)
+	}
+}
 
-			logrus.Debugf("p%d Lockfile was held, sleeping %s", os.Getpid(), duration.String())
-			time.Sleep(duration)
+// waitForSlot waits in line for one of the slots limiting how many files are
+// processed at once. Flags take precedence over the [lock] config, and
+// without a lock dir there is no limit.
+func waitForSlot(ctx context.Context, conf *videoproc.Config, dir string, n int, timeout time.Duration) (func(), error) {
+	if dir == "" {
+		dir = conf.Lock.Dir
+	}
+	if dir == "" {
+		return func() {}, nil
+	}
+	if n <= 0 {
+		n = conf.Lock.Slots
+	}
+	if timeout <= 0 {
+		timeout = time.Duration(conf.Lock.TimeoutMinutes) * time.Minute
+	}
+	if timeout <= 0 {
+		timeout = time.Hour
+	}
+	sem, err := slots.New(dir, n)
+	if err != nil {
+		return nil, err
+	}
+	if waiting := sem.Waiting(); waiting > 0 {
+		logrus.Infof("p%d waiting for a slot behind %d others", os.Getpid(), waiting)
+	}
+	return sem.Acquire(ctx, timeout)
+}
 
-			if duration < 30*time.Second {
-				duration += 100 * time.Millisecond
-			}
+// sweepScratch removes job dirs left in scratch by videoproc runs that died
+// before they could clean up.
+func sweepScratch(conf *videoproc.Config) {
+	olderThan := time.Duration(conf.General.SweepMinutes) * time.Minute
+	if olderThan <= 0 {
+		olderThan = time.Hour
+	}
+	for _, root := range append([]string{conf.General.ScratchDir}, conf.Space.ScratchDirs...) {
+		if root == "" {
+			continue
+		}
+		removed, err := scratch.Sweep(root, olderThan)
+		if err != nil {
+			logrus.Warnf("could not sweep scratch dir %s: %s", root, err.Error())
+		}
+		for _, path := range removed {
+			logrus.Infof("removed %s left in scratch by a videoproc run that died", path)
 		}
 	}
+}
 
-	fileName := flag.Args()[0]
+// subcommands are selected by the first positional argument. Anything else is
+// treated as a single media file to process.
+var subcommands = map[string]func(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error{
+	"batch": runBatch,
+	"watch": runWatch,
+	"queue": runQueueCommand,
+	"serve": runServe,
+
+	"prune-backups":  runPruneBackups,
+	"restore-backup": runRestoreBackup,
+	"review":         runReview,
+	"evaluate":       runEvaluate,
+}
 
-	job := &Job{
-		Config: conf,
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: videoproc [flags] <media file>")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] batch [batch flags] <dirs/files/globs>")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] watch [watch flags] [dirs]")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] queue list|add <files>|retry <ids>|approve <ids>|remove <ids>|run [-jobs N] [-until-empty]")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] serve [-listen addr] [-jobs N]")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] prune-backups [-dry-run] [-max-age-days N] [-max-size-gb N] [media dirs]")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] restore-backup [-overwrite] <files>")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] review [-out dir] [-clip-seconds N] [-comskip-ini file] <files>")
+	fmt.Fprintln(os.Stderr, "       videoproc [flags] evaluate [-comskip-ini file] [-rerun] <dirs/files/globs>")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// signalContexts returns a context that is canceled on the second interrupt
+// and a dispatchCtx, derived from it, that is canceled on the first. Long
+// running commands stop taking new work when dispatchCtx is done and let
+// what's already running finish unless interrupted again.
+func signalContexts() (ctx, dispatchCtx context.Context, cancel func()) {
+	ctx, cancelHard := context.WithCancel(context.Background())
+	dispatchCtx, cancelDispatch := context.WithCancel(ctx)
+
+	go func() {
+		ch := make(chan os.Signal, 2)
+		signal.Notify(ch, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+		<-ch
+		logrus.Warn("Interrupted, not starting any new work. Interrupt again to abort running jobs.")
+		cancelDispatch()
+		<-ch
+		logrus.Warn("Interrupted again, aborting.")
+		cancelHard()
+	}()
+	return ctx, dispatchCtx, func() {
+		cancelDispatch()
+		cancelHard()
+	}
+}
+
+type jobOptions struct {
+	Overrides *videoproc.Overrides
+	// LogFile, if set, gets a copy of the job's log and tool output.
+	LogFile string
+}
+
+// runJob processes a single file and cleans up its scratch files.
+func runJob(ctx context.Context, conf *videoproc.Config, fileName string, opts jobOptions) (*Job, error) {
+	job := NewJob(conf, fileName)
+	job.Overrides = opts.Overrides
+	if ignoreLimits {
+		overrides := videoproc.Overrides{}
+		if opts.Overrides != nil {
+			overrides = *opts.Overrides
+		}
+		overrides.IgnoreLimits = true
+		job.Overrides = &overrides
+	}
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return job, errors.Wrap(err, "open job log")
+		}
+		defer f.Close()
+		job.logTo(f)
+	}
+	// The mkv is locked too, as another recording can be processed into it.
+	locks := []string{fileName}
+	if destFile := stripExtension(fileName) + ".mkv"; destFile != fileName {
+		locks = append(locks, destFile)
+	}
+	for _, name := range locks {
+		unlock, err := lockMediaFile(name)
+		if err == errFileLocked {
+			job.Log.Infof("%s is already being processed elsewhere, skipping", name)
+			job.Skipped = "locked"
+			return job, nil
+		} else if err != nil {
+			return job, err
+		}
+		defer unlock()
+	}
+
+	if err := job.makeScratchDir(conf.General.ScratchDir); err != nil {
+		return job, err
 	}
 	if err := processVideo(ctx, job, fileName); err != nil {
+		job.Log.Errorf("failed: %s", err.Error())
 		job.DeleteErroredFiles()
-		logrus.Fatal(err)
-	} else {
-		job.DeleteFiles()
+		return job, err
 	}
+	job.DeleteFiles()
+	return job, nil
 }
 
 func processVideo(ctx context.Context, job *Job, fileName string) error {
-	scratchDir = job.Config.General.ScratchDir
 	evaluators, err := videoproc.MakeEvaluators(job.Config.Rule)
 	if err != nil {
 		return errors.Wrap(err, "could not build evaluator")
 	}
-	info, err := mediainfo.Parse(context.Background(), fileName)
+	info, err := mediainfo.Parse(ctx, fileName)
 	if err != nil {
 		return errors.Wrap(err, "could not parse mediainfo")
 	}
 
-	c := videoproc.EvalCtx{
-		Name: filepath.Base(fileName),
-	}
-	isMKV := false
-	hasChapters := false
-	for _, track := range info.Media.Tracks {
-		switch v := track.Track.(type) {
-		case *mediainfo.VideoTrack:
-			logrus.Debugf("video %#v", v)
-			c.Height = v.Height.Int()
-			c.Width = v.Width.Int()
-			c.Video.Format = v.Format
-			c.Video.Extra = v.Extra
-			c.Video.FormatVersion = v.FormatVersion
-			c.Video.FormatProfile = v.FormatProfile
-			c.Video.ScanType = v.ScanType
+	c, isMKV, hasChapters := buildEvalCtx(info, fileName)
 
-		case *mediainfo.GeneralTrack:
-			logrus.Debugf("general %#v", v)
-			if v.Format == "Matroska" {
-				isMKV = true
-			}
-			c.Format = v.Format
-			c.DurationSec = v.Duration.Float()
+	job.Log.Debugf("Context %#v", c)
 
-		case *mediainfo.AudioTrack:
-			c.Audio.Format = v.Format
-			c.Audio.Extra = v.Extra
-			c.Audio.BitRate = v.BitRate.Int()
-			c.Audio.Channels = v.Channels.Int()
-			c.Audio.SamplingRate = v.SamplingRate.Int()
-		case *mediainfo.MenuTrack:
-			hasChapters = true
+	decision, labels, err := makeDecision(job.Config, evaluators, c, job.Overrides)
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		job.Log.Infof("MATCHED RULE %v", label)
+	}
+	for _, format := range decision.Exports {
+		if _, err := cutlist.ExportExt(format); err != nil {
+			return err
 		}
 	}
+	if !keyframes.ValidPolicy(decision.KeyframeSnap) {
+		return fmt.Errorf("unknown keyframe-snap %q", decision.KeyframeSnap)
+	}
+	if !cutlist.ValidRounding(decision.Rounding) {
+		return fmt.Errorf("unknown rounding %q", decision.Rounding)
+	}
 
-	logrus.Debugf("Context %#v", c)
-
-	decision := &videoproc.Rule{}
-
-	for i, rule := range job.Config.Rule {
-		output, err := evaluators[i](c)
+	state := readProcessedState(info)
+	// A kept recording is up to date if the mkv made from it is.
+	checked, checkedFile := state, fileName
+	if out, outFile, err := outputState(ctx, fileName); err != nil {
+		job.Log.Warnf("could not check %s: %s", outFile, err.Error())
+	} else if out.Version != "" {
+		checked, checkedFile = out, outFile
+	}
+	if checked.Version != "" && !forceProcess {
+		upToDate, err := checked.UpToDate(job.Config, evaluators, job.Overrides, decision)
 		if err != nil {
-			logrus.Fatal(err)
-		}
-		if !output {
-			continue
+			return err
 		}
-		logrus.Infof("MATCHED RULE %v", rule.Label)
-
-		takeString(&decision.Comskip, rule.Comskip)
-		takeString(&decision.ComskipINI, rule.ComskipINI)
-		takeString(&decision.Profile, rule.Profile)
-		decision.Actions = append(decision.Actions, rule.Actions...)
-		copyEncodeRule(&decision.Encode, rule.Encode)
-	}
-
-	if decision.Profile != "" {
-		for _, profile := range job.Config.Profile {
-			if profile.Name == decision.Profile {
-				cloned := profile
-				copyEncodeRule(&cloned, decision.Encode)
-				decision.Encode = cloned
-			}
+		if upToDate {
+			job.Log.Infof("%s was already processed by videoproc %s with the same decision, skipping", checkedFile, checked.Version)
+			job.Skipped = "up to date"
+			return nil
 		}
+		job.Log.Infof("%s was processed by videoproc %s but the version or decision changed, reprocessing", checkedFile, checked.Version)
 	}
+	outputTags := state.Next(job.Config, evaluators, c, job.Overrides, decision)
 
-	logrus.Debugf("About to execute: %#v", decision)
+	job.Log.Debugf("About to execute: %#v", decision)
 
-	hasMKVChapters := isMKV && hasChapters
+	destFile := stripExtension(fileName) + ".mkv"
+	keptDuration := c.DurationSec
+	srcInfo, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
 
-	var ffmpegOpts []string
+	var ff ffcmd.Command
 	var trackSplitFile string
+	var exportChapters, editionChapters []Chapter
+	chapterInput := "-1"
+	mapMetadata := ""
+
+	chapterRule := decision.Comskip == "chapter" || decision.Comskip == "comchap"
+	var trim *cutlist.Range
+	if manualChop != "" || (cutList != "" && !chapterRule && !slapChop) {
+		// Cuts that only take off the head or tail are trimmed on a stream
+		// copy, as --manual-chop always was.
+		cuts, err := manualCuts(cutList, c.Video.FrameRate)
+		if err != nil {
+			return err
+		}
+		if kept, ok := cutlist.Trim(cuts, c.DurationSec); ok {
+			trim = &kept
+		}
+	}
 
-	if decision.Comskip == "chapter" || decision.Comskip == "comchap" || isTrue(decision.Comskip) {
+	if trim != nil {
+		job.Log.Infof("Trimming to %s-%s", timestampMKV(trim.Begin), timestampMKV(trim.End))
+		ff.AddOutput("-ss", fmt.Sprintf("%.3f", trim.Begin), "-to", fmt.Sprintf("%.3f", trim.End))
+		keptDuration = trim.Duration()
+	} else if cutList != "" || chapterRule || isTrue(decision.Comskip) {
+		if !chapterRule && !slapChop && decision.Encode.Video.Codec == "" && decision.Encode.Audio.Codec == "" {
+			return errors.New("cutting commercials out with filters needs an encode, use chapters, --chop-files or --smart-cut to stream copy")
+		}
 		var chapters []Chapter
-		if useExistingChapters {
-			logrus.Warn("extract chapters from existing")
-			var wlchapters []Chapter
-			var wl *watchlog.WatchLog
-			if wlDir := job.Config.General.WatchLogDir; wlDir != "" {
-				logrus.Debug("check watchlog")
-				wl, err = getWatchLogIfExists(ctx, wlDir, fileName)
-				if err != nil {
-					return err
-				} else if wl != nil {
-					logrus.Info("got watchlog")
-					wlchapters = watchLogChapters(wl)
-					if wl.Note == "prefer" || preferWatchlog {
-						logrus.Info("Preferring watchlog chapters")
-						chapters = wlchapters
-					}
-				}
-			}
-
-			if !preferWatchlog && (wl == nil || wl.Note != "prefer") {
-				chapters, err = extractExistingChapters(ctx, job, fileName)
-				if err != nil {
-					return err
-				}
-				if len(chapters) != 0 {
-					hasMKVChapters = true
-				}
-
-			}
-
-			if len(wlchapters) != 0 {
-				if promptWatchlog {
-					chapters, err = promptWLDecision(ctx, job, wlchapters, chapters)
-					if err != nil {
-						return err
-					}
-				} else if len(chapters) == 0 {
-					chapters = wlchapters
-				}
-			}
-		} else {
-			commercials, err := runComskip(ctx, job, fileName, decision)
+		commercials, err := detectCommercials(ctx, job, fileName, decision, c)
+		if err != nil {
+			return err
+		}
+		chapterMode := chapterRule
+		if decision.Limits != nil && cutList == "" {
+			var ok bool
+			commercials, ok, err = checkLimits(job, decision.Limits, commercials, c.DurationSec, chapterMode)
 			if err != nil {
-				return errors.Wrap(err, "could not run comskip")
+				return err
 			}
-			if len(commercials) != 0 {
-				chapters = makeChapters(commercials, c.DurationSec)
+			chapterMode = chapterMode || !ok
+		}
+		if cutList == "" {
+			// Cuts given by hand are taken as they are.
+			commercials = padCommercials(job, decision, commercials, c.DurationSec)
+		}
+		var unsure []Commercial
+		if !chapterMode && len(commercials) != 0 {
+			sure, maybe, cut := weighCommercials(job, decision, commercials)
+			if cut {
+				commercials, unsure = sure, maybe
+			} else {
+				job.Log.Warn("Comskip isn't confident enough to cut, marking chapters instead")
+				chapterMode = true
 			}
-
+		}
+		if len(commercials) != 0 {
+			chapters = makeChapters(commercials, c.DurationSec)
 		}
 		if len(chapters) != 0 {
 
-			if decision.Comskip == "chapter" || decision.Comskip == "comchap" {
+			if chapterMode {
 				if isMKV {
-					logrus.Warn("Swapping properties using mkvpropedit")
-					if err := editMKVChapters(ctx, job, fileName, chapters); err != nil {
+					job.Log.Warn("Swapping properties using mkvpropedit")
+					if err := editMKVChapters(ctx, job, fileName, chapters, outputTags, decision.ChapterEditions); err != nil {
 						return errors.Wrap(err, "Could not edit MKV chapters")
 					}
-					return nil
+					return writeExports(job, fileName, chapters, decision.Exports)
+				} else if decision.ChapterEditions {
+					// ffmpeg can't write editions, so they're added to its
+					// output afterwards.
+					editionChapters = chapters
+					exportChapters = chapters
 				} else {
-					buf := chaptersToFF(chapters)
-					logrus.Info(string(buf))
-
-					metaFile := filepath.Join(scratchDir, filepath.Base(fileName)+".ffmeta")
-					job.TrackFile(metaFile, false)
-					if err := ioutil.WriteFile(metaFile, buf, 0666); err != nil {
+					metaFile, err := writeChapterMeta(job, fileName, chapters)
+					if err != nil {
 						return err
 					}
-					ffmpegOpts = append(ffmpegOpts, "-i", metaFile, "-map_metadata", "1")
+					chapterInput = ff.AddInput(metaFile)
+					mapMetadata = chapterInput
+					exportChapters = chapters
 				}
 			} else if slapChop {
-				tmpMKV := fileName
-				if hasMKVChapters {
-					tmpMKV, err = temporaryChapterless(ctx, job, fileName)
-					if err != nil {
-						return err
+				keptDuration = chaptersDuration(nonCommercialChapters(chapters))
+				est := estimateSpace(srcInfo.Size(), c.DurationSec, keptDuration, decision)
+				if err := job.ensureSpace(ctx, "track split", est.Scratch(), est.Output, destFile); err != nil {
+					return err
+				}
+				kept := nonCommercialChapters(chapters)
+				if smartCut {
+					trackSplitFile, err = performSmartCut(ctx, job, fileName, kept)
+				} else {
+					if decision.KeyframeSnap != keyframes.None {
+						if kept, err = snapChapters(ctx, job, fileName, kept, decision.KeyframeSnap, c.DurationSec); err != nil {
+							return err
+						}
 					}
+					trackSplitFile, err = performTrackSplit(ctx, job, fileName, kept)
 				}
-
-				trackSplitFile, err = performTrackSplit(ctx, job, tmpMKV, nonCommercialChapters(chapters))
 				if err != nil {
 					return err
 				}
-				if tmpMKV != fileName {
-					os.Remove(tmpMKV)
-				}
 			} else {
+				keptDuration = chaptersDuration(nonCommercialChapters(chapters))
 				extraArgs, _ := ffmpegExtractFilters(ctx, job, fileName, nonCommercialChapters(chapters))
-				logrus.Warn("Extra Filters %+v", extraArgs)
-				ffmpegOpts = append(ffmpegOpts, extraArgs...)
+				job.Log.Warnf("Extra Filters %+v", extraArgs)
+				ff.AddOutput(extraArgs...)
+				mapMetadata = "-1"
 				//				return errors.New("TODO")
 			}
+			if !chapterMode && len(unsure) != 0 {
+				// Mark what's left of the commercials comskip wasn't sure
+				// of on the cut down timeline.
+				var kept []Commercial
+				for _, k := range nonCommercialChapters(chapters) {
+					kept = append(kept, Commercial{Begin: k.Begin, End: k.End})
+				}
+				marked := makeChapters(cutlist.Retime(unsure, kept), keptDuration)
+				for i, n := 0, 1; i < len(marked); i++ {
+					if marked[i].IsCommercial {
+						marked[i].Name = fmt.Sprintf("Possible commercial %d", n)
+						n++
+					}
+				}
+				metaFile, err := writeChapterMeta(job, fileName, marked)
+				if err != nil {
+					return err
+				}
+				chapterInput = ff.AddInput(metaFile)
+				mapMetadata = chapterInput
+			}
 		}
 	}
 
-	if len(decision.Actions) == 0 && len(ffmpegOpts) == 0 && !slapChop && decision.Encode.Video.Codec == "" {
-		logrus.Debug("No actions determined, exiting")
+	if len(decision.Actions) == 0 && !ff.HasOutput() && len(editionChapters) == 0 && trackSplitFile == "" && decision.Encode.Video.Codec == "" {
+		job.Log.Debug("No actions determined, exiting")
+		job.Skipped = "no actions"
 		return nil
 	}
 
@@ -332,70 +538,55 @@ func processVideo(ctx context.Context, job *Job, fileName string) error {
 	for _, action := range decision.Actions {
 		switch action {
 		case "force-anamorphic":
-			ffmpegOpts = append(ffmpegOpts, "-aspect", "16:9")
+			ff.AddOutput("-aspect", "16:9")
 		case "inverse-telecine":
 			ivtc = true
 		default:
-			logrus.Fatalf("unrecognized action %s", action)
+			return fmt.Errorf("unrecognized action %s", action)
 		}
 	}
 
-	baseCmd := []string{"-nostdin"}
+	est := estimateSpace(srcInfo.Size(), c.DurationSec, keptDuration, decision)
+	scratchNeed := est.Scratch()
+	if trackSplitFile != "" {
+		// The parts are already in scratch.
+		scratchNeed = est.Output
+	}
+	if err := job.ensureSpace(ctx, "encode", scratchNeed, est.Output, destFile); err != nil {
+		return err
+	}
 
-	if slapChop {
-		baseCmd = append(baseCmd, "-f", "concat", "-safe", "0", "-i", trackSplitFile)
+	if trackSplitFile != "" {
+		ff.SetInput(trackSplitFile, "-f", "concat", "-safe", "0")
 	} else {
-		inputFileName := fileName
-		if hasMKVChapters {
-			tmpMKV, err := temporaryChapterless(ctx, job, fileName)
-			if err != nil {
-				return err
-			}
-			inputFileName = tmpMKV
-		}
-		baseCmd = append(baseCmd, "-i", inputFileName)
+		ff.SetInput(fileName)
+	}
+	if mapMetadata != "" {
+		ff.AddOutput("-map_metadata", mapMetadata)
+	}
+	if chapterInput != "-1" || (hasChapters && trackSplitFile == "") {
+		// The input's own chapters would be copied through, so take them
+		// from the chapter metadata file if there is one, or leave them out.
+		ff.AddOutput("-map_chapters", chapterInput)
 	}
-	baseCmd = append(baseCmd, ffmpegOpts...)
-
-	baseCmd = append(baseCmd, "-metadata", "videoproc="+FLAG_VER)
-
-	destFile := stripExtension(fileName) + ".mkv"
-
-	tmpOutFile := filepath.Join(scratchDir, filepath.Base(destFile))
 
-	if manualChop != "" {
-		parts := strings.Split(manualChop, " ")
-		if len(parts) > 2 {
-			return errors.New("manual chop must be 1 or 2 parts only")
-		}
-		baseCmd = append(baseCmd, "-ss", strings.TrimSpace(parts[0]))
-		if len(parts) == 2 {
-			baseCmd = append(baseCmd, "-to", strings.TrimSpace(parts[1]))
-		}
+	for _, tag := range outputTags {
+		ff.AddOutput("-metadata", tag.Name+"="+tag.Value)
 	}
 
+	tmpOutFile := job.ScratchFile(filepath.Base(destFile))
+
 	if decision.Encode.Video.Codec == "" && decision.Encode.Audio.Codec == "" {
-		baseCmd = append(baseCmd, "-c", "copy", tmpOutFile)
+		ff.AddOutput("-c", "copy")
 	} else {
-		addArgs := func(args ...string) {
-			baseCmd = append(baseCmd, args...)
-		}
+		addArgs := ff.AddOutput
 		addSimpleArg := func(input string, flag string) {
 			if input != "" {
 				addArgs(flag, input)
 			}
 		}
 		modFilter := func(filter string) {
-			modArg := false
-			for i, arg := range baseCmd {
-				if arg == "-vf" {
-					baseCmd[i+1] += "," + filter
-					modArg = true
-				}
-			}
-			if !modArg {
-				addArgs("-vf", filter)
-			}
+			ff.Filter("-vf", filter)
 		}
 
 		de := decision.Encode
@@ -414,65 +605,192 @@ func processVideo(ctx context.Context, job *Job, fileName string) error {
 		if ivtc {
 			modFilter("decimate")
 		}
-		addArgs(tmpOutFile)
 	}
-	logrus.Debugf("About to ffmpeg %#v", baseCmd)
+	baseCmd := ff.Args([]string{"-nostdin"}, tmpOutFile)
+	job.Log.Debugf("About to ffmpeg %#v", baseCmd)
 
-	if err := runCommand(ctx, "ffmpeg", baseCmd...); err != nil {
-		logrus.Fatal(err)
+	if err := job.runCommand(ctx, "ffmpeg", baseCmd...); err != nil {
+		return errors.Wrap(err, "could not run ffmpeg")
+	}
+	if smartCut && trackSplitFile != "" {
+		if err := checkSmartCut(ctx, job, tmpOutFile); err != nil {
+			return err
+		}
+	}
+	if len(editionChapters) != 0 {
+		if err := editMKVChapters(ctx, job, tmpOutFile, editionChapters, nil, true); err != nil {
+			return errors.Wrap(err, "could not add chapter editions")
+		}
 	}
 
 	if !deleteOriginal && fileName == destFile {
-		backupFile := filepath.Join(filepath.Dir(fileName), "backup.orig."+filepath.Base(fileName))
-		if err = os.Rename(fileName, backupFile); err != nil {
+		if err := backupOriginal(ctx, job, fileName); err != nil {
 			return errors.Wrap(err, "could not backup orig")
 		}
 	}
 
-	if err := fileio.Move(ctx, tmpOutFile, destFile); err != nil {
+	method, err := fileio.Move(ctx, tmpOutFile, destFile)
+	if err != nil {
 		return errors.Wrap(err, "could not move")
 	}
+	job.Log.Debugf("Moved output to %s (%s)", destFile, method)
 	if deleteOriginal && fileName != destFile {
 		if err := os.Remove(fileName); err != nil {
 			return errors.Wrap(err, "could not remove orig")
 		}
 	}
-	return nil
+	return writeExports(job, destFile, exportChapters, decision.Exports)
+}
+
+func buildEvalCtx(info *mediainfo.MediaInfo, fileName string) (c videoproc.EvalCtx, isMKV, hasChapters bool) {
+	c.Name = filepath.Base(fileName)
+	for _, track := range info.Media.Tracks {
+		switch v := track.Track.(type) {
+		case *mediainfo.VideoTrack:
+			logrus.Debugf("video %#v", v)
+			c.Height = v.Height.Int()
+			c.Width = v.Width.Int()
+			c.Video.Format = v.Format
+			c.Video.Extra = v.Extra
+			c.Video.FormatVersion = v.FormatVersion
+			c.Video.FormatProfile = v.FormatProfile
+			c.Video.ScanType = v.ScanType
+			c.Video.FrameRate = v.FrameRate.Float()
+
+		case *mediainfo.GeneralTrack:
+			logrus.Debugf("general %#v", v)
+			if v.Format == "Matroska" {
+				isMKV = true
+			}
+			c.Format = v.Format
+			c.DurationSec = v.Duration.Float()
+
+		case *mediainfo.AudioTrack:
+			c.Audio.Format = v.Format
+			c.Audio.Extra = v.Extra
+			c.Audio.BitRate = v.BitRate.Int()
+			c.Audio.Channels = v.Channels.Int()
+			c.Audio.SamplingRate = v.SamplingRate.Int()
+		case *mediainfo.MenuTrack:
+			hasChapters = true
+		}
+	}
+	return c, isMKV, hasChapters
+}
+
+// makeDecision merges every rule matching c, in config order, into a single
+// rule, followed by any rules forced by overrides. It also returns the labels
+// of the rules that were used.
+func makeDecision(conf *videoproc.Config, evaluators []videoproc.Evaluator, c videoproc.EvalCtx, overrides *videoproc.Overrides) (*videoproc.Rule, []string, error) {
+	decision := &videoproc.Rule{}
+	var labels []string
+
+	for i, rule := range conf.Rule {
+		output, err := evaluators[i](c)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "rule %s", rule.Label)
+		}
+		if !output {
+			continue
+		}
+		labels = append(labels, rule.Label)
+		mergeRule(decision, rule)
+	}
+
+	if overrides != nil {
+		for _, label := range overrides.Rules {
+			rule := findRule(conf, label)
+			if rule == nil {
+				return nil, nil, fmt.Errorf("no rule labeled %s", label)
+			}
+			labels = append(labels, rule.Label)
+			mergeRule(decision, *rule)
+		}
+		takeString(&decision.Comskip, overrides.Comskip)
+		takeString(&decision.Profile, overrides.Profile)
+	}
+
+	if decision.Profile != "" {
+		for _, profile := range conf.Profile {
+			if profile.Name == decision.Profile {
+				cloned := profile
+				copyEncodeRule(&cloned, decision.Encode)
+				decision.Encode = cloned
+			}
+		}
+	}
+	return decision, labels, nil
+}
+
+func mergeRule(decision *videoproc.Rule, rule videoproc.Rule) {
+	takeString(&decision.Comskip, rule.Comskip)
+	takeString(&decision.ComskipINI, rule.ComskipINI)
+	takeString(&decision.Profile, rule.Profile)
+	decision.Actions = append(decision.Actions, rule.Actions...)
+	decision.Exports = append(decision.Exports, rule.Exports...)
+	if len(rule.Detectors) != 0 {
+		decision.Detectors = rule.Detectors
+	}
+	takeString(&decision.DetectorMerge, rule.DetectorMerge)
+	takeString(&decision.DetectorScript, rule.DetectorScript)
+	takeString(&decision.KeyframeSnap, rule.KeyframeSnap)
+	takeFloat(&decision.PaddingBegin, rule.PaddingBegin)
+	takeFloat(&decision.PaddingEnd, rule.PaddingEnd)
+	takeString(&decision.Rounding, rule.Rounding)
+	if rule.Limits != nil {
+		mergeLimits(decision, *rule.Limits)
+	}
+	if rule.ChapterEditions {
+		decision.ChapterEditions = true
+	}
+	if rule.ComskipCutScore != 0 {
+		decision.ComskipCutScore = rule.ComskipCutScore
+	}
+	if rule.ComskipMinConfidence != 0 {
+		decision.ComskipMinConfidence = rule.ComskipMinConfidence
+	}
+	copyEncodeRule(&decision.Encode, rule.Encode)
 }
 
-func temporaryChapterless(ctx context.Context, job *Job, fileName string) (string, error) {
-	logrus.Info("Has MKV chapters, we have to clone the input sadly.")
-	tmpMKV := filepath.Join(scratchDir, filepath.Base(stripExtension(fileName))+".nochap.mkv")
-	job.TrackFile(tmpMKV, true)
-	if err := fileio.Copy(ctx, fileName, tmpMKV); err != nil {
-		return "", errors.Wrap(err, "could not copy file")
+func findRule(conf *videoproc.Config, label string) *videoproc.Rule {
+	for i := range conf.Rule {
+		if conf.Rule[i].Label == label {
+			return &conf.Rule[i]
+		}
 	}
-	if err := runCommand(ctx, "mkvpropedit", tmpMKV, "--chapters", ""); err != nil {
-		return "", errors.Wrap(err, "could not elide chapters")
+	return nil
+}
+
+func findProfile(conf *videoproc.Config, name string) *videoproc.EncodeConfig {
+	for i := range conf.Profile {
+		if conf.Profile[i].Name == name {
+			return &conf.Profile[i]
+		}
 	}
-	return tmpMKV, nil
+	return nil
 }
 
 func performTrackSplit(ctx context.Context, job *Job, fileName string, chapters []Chapter) (string, error) {
 	params := []string{"-nostdin", "-i", fileName}
 	var buf bytes.Buffer
 	for i, c := range chapters {
-		partFile := filepath.Join(job.ScratchDir(), fmt.Sprintf("p%d_tmp%d.ts", os.Getpid(), i))
+		partFile := job.ScratchFile(fmt.Sprintf("tmp%d.ts", i))
 		job.TrackFile(partFile, false)
 		params = append(params,
-			"-ss", strconv.FormatFloat(float64(c.Begin-fuzzBegin), 'f', -1, 64),
-			"-to", strconv.FormatFloat(float64(c.End+fuzzEnd), 'f', -1, 64),
+			"-ss", strconv.FormatFloat(c.Begin, 'f', -1, 64),
+			"-to", strconv.FormatFloat(c.End, 'f', -1, 64),
 			"-c", "copy",
+			"-map_chapters", "-1",
 			partFile,
 		)
 		fmt.Fprintf(&buf, "file '%s'\n", partFile)
 	}
-	logrus.Info("About to track split %+v", params)
+	job.Log.Infof("About to track split %+v", params)
 
-	if err := runCommand(ctx, "ffmpeg", params...); err != nil {
+	if err := job.runCommand(ctx, "ffmpeg", params...); err != nil {
 		return "", err
 	}
-	textFile := job.PidPrefix() + "fpart.txt"
+	textFile := job.ScratchFile("fpart.txt")
 	ioutil.WriteFile(textFile, buf.Bytes(), 0666)
 	job.TrackFile(textFile, true)
 
@@ -480,10 +798,6 @@ func performTrackSplit(ctx context.Context, job *Job, fileName string, chapters
 
 }
 
-func promptWLDecision(ctx context.Context, job *Job, wlchapters, chapters []Chapter) ([]Chapter, error) {
-	return chapters, nil
-}
-
 func nonCommercialChapters(chapters []Chapter) []Chapter {
 	var output []Chapter
 	for _, c := range chapters {
@@ -513,16 +827,84 @@ type TrackedFile struct {
 }
 
 type Job struct {
-	Config       *videoproc.Config
+	ID        int
+	Config    *videoproc.Config
+	Overrides *videoproc.Overrides
+	Log       *logrus.Entry
+	// Skipped is set to the reason when processVideo decided there was nothing to do.
+	Skipped string
+
+	file         string
 	filesTracked []TrackedFile
+	// scratchDir is the job's own dir in scratch. scratchDirs are all the
+	// ones it has used, as it moves to another when short on space.
+	scratchDir  string
+	scratchDirs []string
+	// output also receives the output of tools run for this job.
+	output io.Writer
+	// comskipBlocks are the scored blocks from the last comskip run.
+	comskipBlocks []comskip.Block
+}
+
+var jobSerial int64
+
+func NewJob(conf *videoproc.Config, fileName string) *Job {
+	return &Job{
+		ID:     int(atomic.AddInt64(&jobSerial, 1)),
+		Config: conf,
+		Log:    logrus.WithField("file", filepath.Base(fileName)),
+		file:   fileName,
+	}
 }
 
+// logTo copies the job's log and the output of the tools it runs to w.
+func (job *Job) logTo(w io.Writer) {
+	std := logrus.StandardLogger()
+	logger := logrus.New()
+	logger.Out = io.MultiWriter(std.Out, w)
+	logger.Formatter = std.Formatter
+	logger.Level = std.Level
+	job.Log = logger.WithFields(job.Log.Data)
+	job.output = w
+}
+
+// ScratchDir is the job's own dir in scratch, so concurrent jobs (even on
+// files with the same name) never collide.
 func (job *Job) ScratchDir() string {
-	return job.Config.General.ScratchDir
+	return job.scratchDir
+}
+
+func (job *Job) ScratchFile(name string) string {
+	return filepath.Join(job.ScratchDir(), name)
+}
+
+// makeScratchDir creates the job's dir in root, where it keeps its scratch
+// files from then on.
+func (job *Job) makeScratchDir(root string) error {
+	dir, err := scratch.Create(root, job.ID, job.file)
+	if err != nil {
+		return err
+	}
+	job.scratchDir = dir
+	job.scratchDirs = append(job.scratchDirs, dir)
+	return nil
 }
 
-func (job *Job) PidPrefix() string {
-	return filepath.Join(job.ScratchDir(), fmt.Sprintf("p%d", os.Getpid()))
+func (job *Job) removeScratchDirs() {
+	for _, dir := range job.scratchDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			job.Log.Warnf("could not remove %s: %s", dir, err.Error())
+		}
+	}
+}
+
+func (job *Job) runCommand(ctx context.Context, prog string, args ...string) error {
+	release, err := acquireTool(ctx, prog)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return runCommand(ctx, job.output, prog, args...)
 }
 
 func (job *Job) TrackFile(fileName string, missingOK bool) {
@@ -532,41 +914,70 @@ func (job *Job) DeleteErroredFiles() {
 	for _, entry := range job.filesTracked {
 		os.Remove(entry.Filename)
 	}
+	job.removeScratchDirs()
 }
 
 func (job *Job) DeleteFiles() {
 	for _, entry := range job.filesTracked {
 		_, err := os.Stat(entry.Filename)
 		if err == nil {
-			logrus.Debugf("Deleting %s", entry.Filename)
+			job.Log.Debugf("Deleting %s", entry.Filename)
 			os.Remove(entry.Filename)
 		} else {
 			if os.IsNotExist(err) {
 				if !entry.MissingOK {
-					logrus.Warnf("Expected to delete %s but it was missing", entry.Filename)
+					job.Log.Warnf("Expected to delete %s but it was missing", entry.Filename)
 				}
 			} else {
-				logrus.Warnf("Got error %s deleting %s", err.Error(), entry.Filename)
+				job.Log.Warnf("Got error %s deleting %s", err.Error(), entry.Filename)
 			}
 		}
 	}
+	job.removeScratchDirs()
 }
 
-func editMKVChapters(ctx context.Context, job *Job, fileName string, chapters []Chapter) error {
+// editMKVChapters replaces the chapters in an MKV, and its global tags if
+// any are given. With editions, the chapters are written as a skipping
+// ordered edition and a full one.
+func editMKVChapters(ctx context.Context, job *Job, fileName string, chapters []Chapter, tags []outputTag, editions bool) error {
 	var buf bytes.Buffer
-	for i, chapter := range chapters {
-		cnum := fmt.Sprintf("%02d", i+1)
-		fmt.Fprintf(&buf, "CHAPTER%s=%s\nCHAPTER%sNAME=%s\n", cnum, timestampMKV(chapter.Begin), cnum, chapter.Name)
+	chapterFile := job.ScratchFile(strings.Replace(filepath.Base(fileName), ".mkv", ".chapter", -1))
+	if editions {
+		cutlist.WriteEditions(&buf, chapters)
+		chapterFile += ".xml"
+	} else {
+		for i, chapter := range chapters {
+			cnum := fmt.Sprintf("%02d", i+1)
+			fmt.Fprintf(&buf, "CHAPTER%s=%s\nCHAPTER%sNAME=%s\n", cnum, timestampMKV(chapter.Begin), cnum, chapter.Name)
+		}
 	}
-	logrus.Debug("chapterfile", buf.String())
-	chapterFile := filepath.Join(scratchDir, strings.Replace(filepath.Base(fileName), ".mkv", ".chapter", -1))
+	job.Log.Debug("chapterfile", buf.String())
 	err := ioutil.WriteFile(chapterFile, buf.Bytes(), 0666)
 	job.TrackFile(chapterFile, (err != nil))
 	if err != nil {
 		return err
 	}
+	args := []string{fileName, "--chapters", chapterFile}
 
-	return runCommand(ctx, "mkvpropedit", fileName, "--chapters", chapterFile)
+	if len(tags) != 0 {
+		existing, err := extractMKVTags(ctx, job, fileName)
+		if err != nil {
+			return err
+		}
+		buf, err := mkvTagsXML(existing, tags)
+		if err != nil {
+			return err
+		}
+		tagFile := job.ScratchFile(filepath.Base(stripExtension(fileName)) + ".tags.xml")
+		err = ioutil.WriteFile(tagFile, buf, 0666)
+		job.TrackFile(tagFile, (err != nil))
+		if err != nil {
+			return err
+		}
+		args = append(args, "--tags", "global:"+tagFile)
+	}
+
+	return job.runCommand(ctx, "mkvpropedit", args...)
 }
 
 func timestampMKV(floatSeconds float64) string {
@@ -607,6 +1018,16 @@ func makeChapters(commercials []Commercial, fileDuration float64) []Chapter {
 	return chapters
 }
 
+// writeChapterMeta writes chapters to an ffmpeg metadata file in scratch.
+func writeChapterMeta(job *Job, fileName string, chapters []Chapter) (string, error) {
+	buf := chaptersToFF(chapters)
+	job.Log.Info(string(buf))
+
+	metaFile := job.ScratchFile(filepath.Base(fileName) + ".ffmeta")
+	job.TrackFile(metaFile, false)
+	return metaFile, ioutil.WriteFile(metaFile, buf, 0666)
+}
+
 func chaptersToFF(chapters []Chapter) []byte {
 	var buf bytes.Buffer
 	buf.WriteString(";FFMETADATA1\n")
@@ -617,35 +1038,49 @@ func chaptersToFF(chapters []Chapter) []byte {
 }
 
 func runComskip(ctx context.Context, job *Job, fileName string, decision *videoproc.Rule) ([]Commercial, error) {
-	logrus.Infof("About to run comskip")
-	csPrefix := "vp" + strconv.Itoa(os.Getpid())
+	job.Log.Infof("About to run comskip")
+	absoluteBase := job.ScratchFile("comskip")
+	release, err := acquireTool(ctx, "comskip")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	ctx, cancel := context.WithTimeout(ctx, 1*time.Hour)
 	defer cancel()
-	cmd := exec.CommandContext(
-		ctx,
-		"comskip",
-		"--ini="+decision.ComskipINI,
-		"--output="+scratchDir,
-		"--output-filename="+csPrefix,
+	var args []string
+	if decision.ComskipINI != "" {
+		// Otherwise comskip finds its own comskip.ini.
+		args = append(args, "--ini="+decision.ComskipINI)
+	}
+	args = append(args,
+		"--output="+filepath.Dir(absoluteBase),
+		"--output-filename="+filepath.Base(absoluteBase),
 		"--verbose=1",
 		fileName,
 	)
+	cmd := exec.CommandContext(ctx, "comskip", args...)
 	sbuf := &stdbuf{Name: "stdout"}
 	cmd.Stdout = sbuf
 	cmd.Stderr = os.Stderr
+	if job.output != nil {
+		cmd.Stdout = io.MultiWriter(sbuf, job.output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, job.output)
+	}
 
-	logrus.Debug(cmd.Args)
-	absoluteBase := filepath.Join(scratchDir, csPrefix)
+	job.Log.Debug(cmd.Args)
 	job.TrackFile(absoluteBase+".ccyes", true)
 	job.TrackFile(absoluteBase+".ccno", true)
 	job.TrackFile(absoluteBase+".edl", true)
 	job.TrackFile(absoluteBase+".txt", true)
 	job.TrackFile(absoluteBase+".log", true)
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, transient(errors.Wrap(err, "comskip timed out"))
+		}
 		scanner := bufio.NewScanner(&sbuf.buf)
 		for scanner.Scan() {
 			if strings.TrimSpace(scanner.Text()) == "Commercials were not found." {
-				logrus.Debug("Detected failure in comskip but got comm not found, moving on.")
+				job.Log.Debug("Detected failure in comskip but got comm not found, moving on.")
 				return nil, nil
 			}
 		}
@@ -653,7 +1088,7 @@ func runComskip(ctx context.Context, job *Job, fileName string, decision *videop
 		return nil, errors.Wrap(err, "could not run comskip")
 	}
 
-	return edlToCommercials(absoluteBase + ".edl")
+	return cutlist.ReadFile(absoluteBase+".edl", cutlist.EDL, 0)
 }
 
 type stdbuf struct {
@@ -669,41 +1104,9 @@ func (b *stdbuf) Write(v []byte) (int, error) {
 	return b.buf.Write(v)
 }
 
-func edlToCommercials(edlFile string) ([]Commercial, error) {
-	f, err := os.Open(edlFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not open EDL")
-	}
-	defer f.Close()
-	reader := csv.NewReader(f)
-	reader.Comma = '\t'
-	edits, err := reader.ReadAll()
-	if err != nil {
-		return nil, errors.Wrap(err, "could not parse CSV")
-	}
+type Commercial = cutlist.Range
 
-	logrus.Warnf("edits: %#v", edits)
-	var commercials []Commercial
-	for _, edit := range edits {
-		begin, _ := strconv.ParseFloat(edit[0], 64)
-		end, _ := strconv.ParseFloat(edit[1], 64)
-		commercials = append(commercials, Commercial{begin, end})
-	}
-	return commercials, nil
-
-}
-
-type Commercial struct {
-	Begin float64
-	End   float64
-}
-
-type Chapter struct {
-	Begin        float64
-	End          float64
-	Name         string
-	IsCommercial bool
-}
+type Chapter = cutlist.Chapter
 
 func takeString(existing *string, updated string) {
 	if updated != "" {
@@ -711,6 +1114,12 @@ func takeString(existing *string, updated string) {
 	}
 }
 
+func takeFloat(existing *float64, updated float64) {
+	if updated != 0 {
+		*existing = updated
+	}
+}
+
 func isFalse(v string) bool {
 	v = strings.ToLower(v)
 	return v == "" || v == "false" || v == "no" || v == "disable"
@@ -726,23 +1135,45 @@ func stripExtension(fileName string) string {
 	return fileName[:i]
 }
 
-func runCommand(ctx context.Context, prog string, args ...string) error {
+// runCommand runs prog, sending its output to ours and also to extraOutput if set.
+func runCommand(ctx context.Context, extraOutput io.Writer, prog string, args ...string) error {
 	cmd := exec.CommandContext(ctx, prog, args...)
+	stderr := &tailBuffer{Max: 4096}
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	if extraOutput != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, extraOutput)
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr, extraOutput)
+	}
+	err := cmd.Run()
+	if err != nil && bytes.Contains(stderr.Bytes(), []byte("No space left on device")) {
+		return transient(errors.Wrapf(err, "%s ran out of disk space", prog))
+	}
+	return err
+}
+
+// tailBuffer keeps only the last Max bytes written to it.
+type tailBuffer struct {
+	Max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(v []byte) (int, error) {
+	t.buf = append(t.buf, v...)
+	if over := len(t.buf) - t.Max; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(v), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
 }
 
 func ffmpegExtractFilters(ctx context.Context, job *Job, filename string, chapters []Chapter) ([]string, error) {
 	var vselect []string
 	for _, chapter := range chapters {
-		begin := chapter.Begin
-		end := chapter.End
-		if job.Config.General.RoundCuts {
-			begin = math.Floor(begin)
-			end = math.Ceil(end)
-		}
-		vselect = append(vselect, fmt.Sprintf("between(t,%.2f,%.2f)", begin, end))
+		vselect = append(vselect, fmt.Sprintf("between(t,%.2f,%.2f)", chapter.Begin, chapter.End))
 	}
 	betweens := strings.Join(vselect, "+")
 
@@ -753,20 +1184,19 @@ func ffmpegExtractFilters(ctx context.Context, job *Job, filename string, chapte
 		"-af", fmt.Sprintf("aselect='%s',asetpts=N/SR/TB", betweens),
 		//		"-c", "copy",
 		//		extractedFile,
-		"-map_metadata", "-1",
 	}
 
 	//:= "select='between(t,4,6.5)+between(t,17,26)+between(t,74,91)',setpts=N/FRAME_RATE/TB" -af "aselect='between(t,4,6.5)+between(t,17,26)+between(t,74,91)"
 	//job.TrackFile(extractedFile, false)
 	//	logrus.Infof("About to extract ffmpeg")
-	//	err := runCommand(ctx, "ffmpeg", args...)
+	//	err := job.runCommand(ctx, "ffmpeg", args...)
 	return args, nil
 
 }
 
 func extractExistingChapters(ctx context.Context, job *Job, fileName string) ([]Chapter, error) {
-	chapterFile := filepath.Join(scratchDir, filepath.Base(stripExtension(fileName))+".extracted.ffmeta")
-	err := runCommand(ctx, "ffmpeg", "-i", fileName, "-f", "ffmetadata", chapterFile)
+	chapterFile := job.ScratchFile(filepath.Base(stripExtension(fileName)) + ".extracted.ffmeta")
+	err := job.runCommand(ctx, "ffmpeg", "-i", fileName, "-f", "ffmetadata", chapterFile)
 	job.TrackFile(chapterFile, err != nil)
 	if err != nil {
 		return nil, err
@@ -800,7 +1230,7 @@ func extractExistingChapters(ctx context.Context, job *Job, fileName string) ([]
 			}
 		}
 	}
-	logrus.Warn(chapters)
+	job.Log.Warn(chapters)
 	return chapters, nil
 }
 
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/timescale"
+)
+
+// manualChopCuts turns the old --manual-chop "begin [end]", the part to
+// keep, into the cuts either side of it.
+func manualChopCuts(chop string) (string, error) {
+	parts := strings.Fields(chop)
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", fmt.Errorf("manual chop must be 1 or 2 parts only")
+	}
+	var cuts []string
+	begin, err := timescale.Parse(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if begin > 0 {
+		cuts = append(cuts, "0-"+parts[0])
+	}
+	if len(parts) == 2 {
+		cuts = append(cuts, parts[1]+"-end")
+	}
+	if len(cuts) == 0 {
+		return "", fmt.Errorf("manual chop %q keeps everything", chop)
+	}
+	return strings.Join(cuts, ","), nil
+}
+
+// manualCuts reads the cuts given with --cut. spec is either the cuts
+// themselves or a file of them; a file in a sidecar format is read as one,
+// with fps for its frame numbers.
+func manualCuts(spec string, fps float64) ([]Commercial, error) {
+	if _, err := os.Stat(spec); err != nil {
+		return cutlist.ParseCuts(spec)
+	}
+	if format := cutlist.FormatFor(spec); format != "" {
+		return cutlist.ReadFile(spec, format, fps)
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return cutlist.ReadCuts(f)
+}
+
+// manualDetector uses the cuts given with --cut.
+type manualDetector struct {
+	spec     string
+	fps      float64
+	duration float64
+}
+
+func (d manualDetector) Detect(ctx context.Context, job *Job, fileName string) ([]Commercial, error) {
+	cuts, err := manualCuts(d.spec, d.fps)
+	if err != nil {
+		return nil, err
+	}
+	return clampCuts(cuts, d.duration), nil
+}
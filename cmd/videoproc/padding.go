@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/cutlist"
+)
+
+// padCommercials applies the rule's padding and rounding to commercials.
+// The deprecated --fuzz-begin/--fuzz-end flags and round-cuts stand in for
+// rules that don't set their own.
+func padCommercials(job *Job, decision *videoproc.Rule, commercials []Commercial, duration float64) []Commercial {
+	p := cutlist.Padding{Begin: decision.PaddingBegin, End: decision.PaddingEnd, Rounding: decision.Rounding}
+	if p.Begin == 0 {
+		p.Begin = fuzzBegin
+	}
+	if p.End == 0 {
+		p.End = fuzzEnd
+	}
+	if p.Rounding == cutlist.NoRounding && job.Config.General.RoundCuts {
+		p.Rounding = cutlist.RoundKeepMore
+	}
+	if p == (cutlist.Padding{}) || len(commercials) == 0 {
+		return commercials
+	}
+	padded := p.Apply(commercials, duration)
+	job.Log.Infof("Padded commercials by %+.3fs/%+.3fs, rounding %q: %v", p.Begin, p.End, p.Rounding, padded)
+	return padded
+}
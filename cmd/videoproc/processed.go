@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/mediainfo"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Tags written into every output file so a rerun can tell what produced it.
+const (
+	tagVersion  = "videoproc"
+	tagDecision = "videoproc_decision"
+	tagSource   = "videoproc_source"
+)
+
+type outputTag struct {
+	Name  string
+	Value string
+}
+
+// processedState is what a previous videoproc run recorded in a file's tags.
+// A zero Version means the file was never processed.
+type processedState struct {
+	Version  string
+	Decision string
+	Source   *sourceInfo
+}
+
+// sourceInfo describes the original recording and any per-job overrides it
+// was processed with, which together determine its decision.
+type sourceInfo struct {
+	videoproc.EvalCtx
+	Overrides *videoproc.Overrides `json:",omitempty"`
+}
+
+func readProcessedState(info *mediainfo.MediaInfo) processedState {
+	var state processedState
+	for _, track := range info.Media.Tracks {
+		general, ok := track.Track.(*mediainfo.GeneralTrack)
+		if !ok {
+			continue
+		}
+		for k, v := range general.Extra {
+			switch strings.ToLower(k) {
+			case tagVersion:
+				state.Version = v
+			case tagDecision:
+				state.Decision = v
+			case tagSource:
+				state.Source = decodeSourceCtx(v)
+			}
+		}
+	}
+	return state
+}
+
+// outputState reads the state of the mkv that processing fileName writes,
+// when that's another file and it exists.
+func outputState(ctx context.Context, fileName string) (state processedState, destFile string, err error) {
+	destFile = stripExtension(fileName) + ".mkv"
+	if destFile == fileName {
+		return state, destFile, nil
+	}
+	if _, err := os.Stat(destFile); err != nil {
+		return state, destFile, nil
+	}
+	info, err := mediainfo.Parse(ctx, destFile)
+	if err != nil {
+		return state, destFile, err
+	}
+	return readProcessedState(info), destFile, nil
+}
+
+// UpToDate reports whether the file was produced by this videoproc version
+// with the decision the current config would make for the original file.
+// Overrides given for this run replace those recorded from earlier runs.
+// Files tagged before decision hashes existed are treated as up to date.
+func (s processedState) UpToDate(conf *videoproc.Config, evaluators []videoproc.Evaluator, overrides *videoproc.Overrides, decision *videoproc.Rule) (bool, error) {
+	if s.Version != FLAG_VER {
+		return false, nil
+	}
+	if s.Decision == "" {
+		return true, nil
+	}
+	sourceDecision, err := s.sourceDecision(conf, evaluators, overrides, decision)
+	if err != nil {
+		return false, err
+	}
+	return decisionHash(sourceDecision) == s.Decision, nil
+}
+
+// Next returns the tags to write on the output of processing with decision.
+// The original source context is carried forward across reprocessing so the
+// decision hash keeps describing the original recording.
+func (s processedState) Next(conf *videoproc.Config, evaluators []videoproc.Evaluator, c videoproc.EvalCtx, overrides *videoproc.Overrides, decision *videoproc.Rule) []outputTag {
+	source := &sourceInfo{EvalCtx: c}
+	if s.Source != nil {
+		source.EvalCtx = s.Source.EvalCtx
+		source.Overrides = s.Source.Overrides
+	}
+	if overrides != nil {
+		source.Overrides = overrides
+	}
+	sourceDecision, err := s.sourceDecision(conf, evaluators, overrides, decision)
+	if err != nil {
+		logrus.Warnf("could not evaluate decision for original source: %s", err.Error())
+		sourceDecision = decision
+	}
+	return []outputTag{
+		{tagVersion, FLAG_VER},
+		{tagDecision, decisionHash(sourceDecision)},
+		{tagSource, encodeSourceCtx(source)},
+	}
+}
+
+func (s processedState) sourceDecision(conf *videoproc.Config, evaluators []videoproc.Evaluator, overrides *videoproc.Overrides, decision *videoproc.Rule) (*videoproc.Rule, error) {
+	if s.Source == nil {
+		return decision, nil
+	}
+	if overrides == nil {
+		overrides = s.Source.Overrides
+	}
+	sourceDecision, _, err := makeDecision(conf, evaluators, s.Source.EvalCtx, overrides)
+	return sourceDecision, err
+}
+
+// decisionHash summarizes everything that affects the output for a file:
+// the merged rule plus the command-line options that change processing.
+func decisionHash(decision *videoproc.Rule) string {
+	cut := ""
+	if cutList != "" && manualChop == "" {
+		// --manual-chop is already in the hash, as it was before --cut.
+		cut = cutsKey(cutList)
+	}
+	buf, _ := json.Marshal(struct {
+		Decision         *videoproc.Rule
+		ChopFiles        bool
+		ManualChop       string
+		ExistingChapters bool
+		SmartCut         bool   `json:",omitempty"`
+		Cut              string `json:",omitempty"`
+	}{decision, slapChop, manualChop, useExistingChapters, smartCut, cut})
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:8])
+}
+
+// cutsKey describes the cuts given with --cut by what they cut rather than
+// how they were given, so editing a file of them changes the decision.
+// Frame numbered files can't be read without a frame rate, so those are
+// described by their contents.
+func cutsKey(spec string) string {
+	if cuts, err := manualCuts(spec, 0); err == nil {
+		return fmt.Sprint(cuts)
+	}
+	buf, err := ioutil.ReadFile(spec)
+	if err != nil {
+		return spec
+	}
+	return string(buf)
+}
+
+func encodeSourceCtx(c *sourceInfo) string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeSourceCtx(v string) *sourceInfo {
+	buf, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		logrus.Warnf("could not decode %s tag: %s", tagSource, err.Error())
+		return nil
+	}
+	var c sourceInfo
+	if err := json.Unmarshal(buf, &c); err != nil {
+		logrus.Warnf("could not decode %s tag: %s", tagSource, err.Error())
+		return nil
+	}
+	return &c
+}
+
+type mkvTags struct {
+	XMLName xml.Name `xml:"Tags"`
+	Tag     []mkvTag `xml:"Tag"`
+}
+
+type mkvTag struct {
+	Targets mkvTargets     `xml:"Targets"`
+	Simple  []mkvSimpleTag `xml:"Simple"`
+}
+
+// mkvTargets keeps what it was read with, so tags are written back as they
+// were. The UIDs tell global tags from track, edition and chapter ones.
+type mkvTargets struct {
+	TrackUID      []string
+	EditionUID    []string
+	ChapterUID    []string
+	AttachmentUID []string
+	Inner         string `xml:",innerxml"`
+}
+
+func (t mkvTargets) global() bool {
+	return len(t.TrackUID)+len(t.EditionUID)+len(t.ChapterUID)+len(t.AttachmentUID) == 0
+}
+
+// mkvSimpleTag is either one of videoproc's tags, or one read from the file
+// which is written back from Inner as it was.
+type mkvSimpleTag struct {
+	Name   string `xml:",omitempty"`
+	String string `xml:",omitempty"`
+	Inner  string `xml:",innerxml"`
+}
+
+// mkvTagsXML renders tags as a Matroska global tags file for mkvpropedit,
+// which replaces all of a file's global tags with it. existing is the file's
+// tags as mkvextract wrote them, whose global tags are carried over apart
+// from videoproc's own.
+func mkvTagsXML(existing []byte, tags []outputTag) ([]byte, error) {
+	var old, doc mkvTags
+	if len(bytes.TrimSpace(existing)) != 0 {
+		if err := xml.Unmarshal(existing, &old); err != nil {
+			return nil, errors.Wrap(err, "could not parse tags")
+		}
+	}
+	ours := map[string]bool{}
+	for _, tag := range tags {
+		ours[strings.ToUpper(tag.Name)] = true
+	}
+	for _, t := range old.Tag {
+		if !t.Targets.global() {
+			continue
+		}
+		var kept []mkvSimpleTag
+		for _, simple := range t.Simple {
+			if !ours[strings.ToUpper(simple.Name)] {
+				kept = append(kept, mkvSimpleTag{Inner: simple.Inner})
+			}
+		}
+		if len(kept) != 0 {
+			doc.Tag = append(doc.Tag, mkvTag{t.Targets, kept})
+		}
+	}
+	var videoprocTag mkvTag
+	for _, tag := range tags {
+		videoprocTag.Simple = append(videoprocTag.Simple, mkvSimpleTag{Name: strings.ToUpper(tag.Name), String: tag.Value})
+	}
+	doc.Tag = append(doc.Tag, videoprocTag)
+	buf, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), buf...), nil
+}
+
+// extractMKVTags returns an MKV's tags as XML, or nothing if it has none.
+func extractMKVTags(ctx context.Context, job *Job, fileName string) ([]byte, error) {
+	tagFile := job.ScratchFile(filepath.Base(stripExtension(fileName)) + ".oldtags.xml")
+	job.TrackFile(tagFile, true)
+	if err := job.runCommand(ctx, "mkvextract", fileName, "tags", tagFile); err != nil {
+		return nil, errors.Wrap(err, "could not read tags")
+	}
+	buf, err := ioutil.ReadFile(tagFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buf, err
+}
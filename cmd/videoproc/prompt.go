@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/jsonio"
+	"github.com/crast/dvr-tools/watchlog"
+	"github.com/pkg/errors"
+)
+
+// Choices made at the prompt, as saved to the watchlog's Note. "prefer" is
+// the same note seeker writes, which --existing-chapters also honors.
+const (
+	noteWatchlog = "prefer"
+	noteDetector = "detector"
+	noteMerge    = "merge"
+	noteCuts     = "cuts:"
+	// noCuts follows noteCuts when every commercial was removed.
+	noCuts = "none"
+)
+
+// promptDetections asks which commercials to use when there's a watchlog to
+// set against the detectors, and saves the answer to the watchlog's Note.
+// A saved answer is offered first on later runs.
+func promptDetections(ctx context.Context, job *Job, fileName string, duration float64, names []string, lists [][]Commercial) ([]Commercial, error) {
+	var watched []Commercial
+	var others [][]Commercial
+	for i, name := range names {
+		if name == "watchlog" {
+			watched = lists[i]
+		} else {
+			others = append(others, lists[i])
+		}
+	}
+	detected, err := cutlist.Merge(cutlist.FirstNonEmpty, others)
+	if err != nil {
+		return nil, err
+	}
+
+	wlDir := job.Config.General.WatchLogDir
+	if wlDir == "" {
+		job.Log.Info("No watch-log-dir, nothing to prompt about")
+		return detected, nil
+	}
+	wl, err := getWatchLogIfExists(ctx, wlDir, fileName)
+	if err != nil || wl == nil {
+		job.Log.Info("No watchlog, nothing to prompt about")
+		return detected, err
+	}
+	if watched == nil {
+		if watched, err = (watchlogDetector{duration}).Detect(ctx, job, fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("\n%s\n", fileName)
+	printComparison(watched, detected)
+
+	if saved, ok := savedChoice(wl.Note, watched, detected, duration); ok {
+		if confirm(fmt.Sprintf("Use the saved choice %q?", wl.Note), 3) {
+			return saved, nil
+		}
+	}
+
+	ok, choice := makeChoice("Use which commercials", []string{"watchlog", "detector", "merge", "edit"}, 3)
+	if !ok {
+		return nil, errors.New("no choice made at the prompt")
+	}
+	var chosen []Commercial
+	var note string
+	switch choice {
+	case "watchlog":
+		chosen, note = watched, noteWatchlog
+	case "detector":
+		chosen, note = detected, noteDetector
+	case "merge":
+		chosen, _ = cutlist.Merge(cutlist.Union, [][]Commercial{watched, detected})
+		note = noteMerge
+	case "edit":
+		merged, _ := cutlist.Merge(cutlist.Union, [][]Commercial{watched, detected})
+		chosen = editCommercials(merged, duration)
+		note = noteCuts + formatCuts(chosen)
+		if len(chosen) == 0 {
+			note = noteCuts + noCuts
+		}
+	}
+
+	wl.Note = note
+	wlFile, err := watchlog.GenName(wlDir, fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "watchlog")
+	}
+	if err := jsonio.WriteFile(wlFile, wl); err != nil {
+		return nil, errors.Wrap(err, "could not save choice to watchlog")
+	}
+	job.Log.Infof("Saved choice %q to watchlog %s", note, wlFile)
+	return chosen, nil
+}
+
+// savedChoice returns the commercials a choice saved in a watchlog's Note
+// picks, if it's one.
+func savedChoice(note string, watched, detected []Commercial, duration float64) ([]Commercial, bool) {
+	switch {
+	case note == noteWatchlog:
+		return watched, true
+	case note == noteDetector:
+		return detected, true
+	case note == noteMerge:
+		merged, _ := cutlist.Merge(cutlist.Union, [][]Commercial{watched, detected})
+		return merged, true
+	case note == noteCuts+noCuts:
+		return []Commercial{}, true
+	case strings.HasPrefix(note, noteCuts):
+		cuts, err := cutlist.ParseCuts(strings.TrimPrefix(note, noteCuts))
+		if err != nil {
+			return nil, false
+		}
+		return clampCuts(cuts, duration), true
+	}
+	return nil, false
+}
+
+// printComparison shows the watchlog's commercials beside the detectors'.
+// Matching ones are marked =, ones whose boundaries differ ~, and ones only
+// one side has < or >.
+func printComparison(watched, detected []Commercial) {
+	fmt.Printf("   %-27s  %-27s\n", "Watchlog", "Detector")
+	for _, p := range cutlist.Compare(watched, detected) {
+		left, right := "", ""
+		if p.InA {
+			left = timestampMKV(p.A.Begin) + "-" + timestampMKV(p.A.End)
+		}
+		if p.InB {
+			right = timestampMKV(p.B.Begin) + "-" + timestampMKV(p.B.End)
+		}
+		mark, extra := "<", ""
+		switch {
+		case p.InA && p.InB && p.Difference < 1:
+			mark = "="
+		case p.InA && p.InB:
+			mark = "~"
+			extra = fmt.Sprintf("  begin %+.1fs, end %+.1fs", p.B.Begin-p.A.Begin, p.B.End-p.A.End)
+		case p.InB:
+			mark = ">"
+		}
+		fmt.Printf(" %s %-27s  %-27s%s\n", mark, left, right, extra)
+	}
+}
+
+// editCommercials lets the operator change, remove and add commercials one
+// at a time, until they enter a blank line.
+func editCommercials(commercials []Commercial, duration float64) []Commercial {
+	for {
+		fmt.Println()
+		for i, c := range commercials {
+			fmt.Printf(" %2d %s-%s\n", i+1, timestampMKV(c.Begin), timestampMKV(c.End))
+		}
+		line := readLine("Edit (N begin-end to change, -N to remove, + begin-end to add, blank when done)")
+		if line == "" {
+			return commercials
+		}
+		var err error
+		switch fields := strings.Fields(line); {
+		case fields[0] == "+":
+			var cuts []Commercial
+			if cuts, err = cutlist.ParseCuts(strings.Join(fields[1:], "")); err == nil {
+				commercials = append(commercials, clampCuts(cuts, duration)...)
+			}
+		case strings.HasPrefix(fields[0], "-"):
+			var i int
+			if i, err = commercialNumber(fields[0][1:], commercials); err == nil {
+				commercials = append(commercials[:i], commercials[i+1:]...)
+			}
+		case len(fields) == 2:
+			var i int
+			var cuts []Commercial
+			if i, err = commercialNumber(fields[0], commercials); err == nil {
+				if cuts, err = cutlist.ParseCuts(fields[1]); err == nil {
+					commercials = append(commercials[:i], commercials[i+1:]...)
+					commercials = append(commercials, clampCuts(cuts, duration)...)
+				}
+			}
+		default:
+			err = fmt.Errorf("didn't understand %q", line)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		commercials = cutlist.Normalize(commercials)
+	}
+}
+
+func commercialNumber(s string, commercials []Commercial) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(commercials) {
+		return 0, fmt.Errorf("no commercial %s", s)
+	}
+	return n - 1, nil
+}
+
+// clampCuts ends cuts that run to "end" at the end of the recording.
+func clampCuts(cuts []Commercial, duration float64) []Commercial {
+	out := []Commercial{}
+	for _, c := range cuts {
+		c.End = math.Min(c.End, duration)
+		if c.End > c.Begin {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// formatCuts writes commercials as cutlist.ParseCuts reads them.
+func formatCuts(commercials []Commercial) string {
+	var parts []string
+	for _, c := range commercials {
+		parts = append(parts, timestampMKV(c.Begin)+"-"+timestampMKV(c.End))
+	}
+	return strings.Join(parts, ",")
+}
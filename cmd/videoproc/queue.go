@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/jobqueue"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func openQueue(conf *videoproc.Config) (*jobqueue.Queue, error) {
+	dir := conf.Queue.Dir
+	if dir == "" {
+		dir = filepath.Join(conf.General.ScratchDir, "queue")
+	}
+	return jobqueue.Open(dir)
+}
+
+// transientError marks a failure that may well succeed if the job is retried
+// later, such as a full disk or a tool that was killed.
+type transientError struct {
+	error
+}
+
+func (e transientError) Cause() error {
+	return e.error
+}
+
+func transient(err error) error {
+	return transientError{err}
+}
+
+type causer interface {
+	Cause() error
+}
+
+func isTransient(err error) bool {
+	for err != nil {
+		if _, ok := err.(transientError); ok {
+			return true
+		}
+		if errors.Is(err, syscall.ENOSPC) {
+			return true
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				return true
+			}
+		}
+		c, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// retryDelay is the exponential backoff before attempt number attempts+1.
+func retryDelay(qc videoproc.QueueConfig, attempts int) time.Duration {
+	base := qc.RetryMinutes
+	if base <= 0 {
+		base = 5
+	}
+	max := qc.MaxRetryMinutes
+	if max <= 0 {
+		max = 6 * 60
+	}
+	minutes := base
+	for i := 1; i < attempts && minutes < max; i++ {
+		minutes *= 2
+	}
+	if minutes > max {
+		minutes = max
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func maxAttempts(qc videoproc.QueueConfig) int {
+	if qc.MaxAttempts <= 0 {
+		return 5
+	}
+	return qc.MaxAttempts
+}
+
+// queueRunner claims jobs from the queue and processes them with a fixed
+// number of workers until dispatchCtx is done.
+type queueRunner struct {
+	Queue   *jobqueue.Queue
+	Config  *videoproc.Config
+	Workers int
+	Poll    time.Duration
+	// BeforeClaim, if set, runs before each round of claiming jobs.
+	BeforeClaim func()
+	// UntilEmpty makes Run return once nothing is running or ready to run.
+	UntilEmpty bool
+}
+
+func (r *queueRunner) Run(ctx, dispatchCtx context.Context) {
+	running := 0
+	finished := make(chan struct{})
+	stopping := dispatchCtx.Done()
+	for {
+		if dispatchCtx.Err() == nil {
+			if r.BeforeClaim != nil {
+				r.BeforeClaim()
+			}
+			for running < r.Workers {
+				qj, err := r.Queue.Claim(time.Now())
+				if err != nil {
+					logrus.Warnf("queue: could not claim a job: %s", err.Error())
+					break
+				} else if qj == nil {
+					break
+				}
+				running++
+				go func() {
+					r.runOne(ctx, qj)
+					finished <- struct{}{}
+				}()
+			}
+		} else {
+			stopping = nil
+		}
+		if running == 0 && (dispatchCtx.Err() != nil || r.UntilEmpty) {
+			return
+		}
+		select {
+		case <-finished:
+			running--
+		case <-stopping:
+		case <-time.After(r.Poll):
+		}
+	}
+}
+
+func (r *queueRunner) runOne(ctx context.Context, qj *jobqueue.Job) {
+	logrus.Infof("queue: starting job %d %s (attempt %d)", qj.ID, qj.File, qj.Attempts)
+	result := processFile(ctx, r.Config, qj.File, jobOptions{
+		Overrides: qj.Overrides,
+		LogFile:   r.Queue.LogFile(qj.ID),
+	})
+	var err error
+	switch {
+	case result.Status == statusSkipped && result.Detail == "locked":
+		err = r.Queue.Requeue(qj, time.Now().Add(r.Poll))
+	case result.Status != statusFailed:
+		logrus.Infof("queue: job %d %s in %s", qj.ID, result.Status, result.Duration.Round(time.Second))
+		err = r.Queue.Complete(qj, strings.TrimSpace(result.Status+" "+result.Detail))
+	case ctx.Err() != nil:
+		logrus.Warnf("queue: job %d was interrupted, returning it to the queue", qj.ID)
+		err = r.Queue.Requeue(qj, time.Time{})
+	case isHeld(result.Err):
+		logrus.Warnf("queue: job %d %s", qj.ID, result.Detail)
+		err = r.Queue.Hold(qj, result.Err)
+	case isTransient(result.Err) && qj.Attempts < maxAttempts(r.Config.Queue):
+		delay := retryDelay(r.Config.Queue, qj.Attempts)
+		logrus.Warnf("queue: job %d failed, retrying in %s: %s", qj.ID, delay, result.Detail)
+		err = r.Queue.RetryAt(qj, time.Now().Add(delay), result.Err)
+	default:
+		logrus.Errorf("queue: job %d failed permanently: %s", qj.ID, result.Detail)
+		err = r.Queue.Park(qj, result.Err)
+	}
+	if err != nil {
+		logrus.Errorf("queue: could not update job %d: %s", qj.ID, err.Error())
+	}
+}
+
+// enqueueForRetry saves a file that couldn't be processed now so that a queue
+// runner picks it up after delay, instead of the work being lost. It returns
+// false if the file couldn't be queued.
+func enqueueForRetry(conf *videoproc.Config, fileName string, delay time.Duration, cause error) bool {
+	q, err := openQueue(conf)
+	if err != nil {
+		logrus.Errorf("could not open queue to retry %s: %s", fileName, err.Error())
+		return false
+	}
+	abs, _ := filepath.Abs(fileName)
+	qj, err := q.Add(abs, nil)
+	if err == jobqueue.ErrConflict {
+		logrus.Warnf("%s is already queued as job %d", fileName, qj.ID)
+		return true
+	}
+	if err == nil {
+		err = q.RetryAt(qj, time.Now().Add(delay), cause)
+	}
+	if err != nil {
+		logrus.Errorf("could not queue %s for retry: %s", fileName, err.Error())
+		return false
+	}
+	logrus.Warnf("queued %s as job %d to retry later", fileName, qj.ID)
+	return true
+}
+
+// holdForReview adds a file whose commercials broke its limits to the queue
+// as held, so it can be approved later. It returns false if it couldn't be
+// held.
+func holdForReview(conf *videoproc.Config, fileName string, cause error) bool {
+	q, err := openQueue(conf)
+	if err != nil {
+		logrus.Errorf("could not open queue to hold %s, rerun with --ignore-limits to cut it anyway: %s", fileName, err.Error())
+		return false
+	}
+	abs, _ := filepath.Abs(fileName)
+	qj, err := q.Add(abs, nil)
+	if err == jobqueue.ErrConflict {
+		logrus.Warnf("%s is already queued as job %d", fileName, qj.ID)
+		return true
+	}
+	if err == nil {
+		err = q.Hold(qj, cause)
+	}
+	if err != nil {
+		logrus.Errorf("could not hold %s: %s", fileName, err.Error())
+		return false
+	}
+	logrus.Warnf("held %s as job %d, approve it with: videoproc queue approve %d", fileName, qj.ID, qj.ID)
+	return true
+}
+
+func runQueueCommand(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: videoproc queue list|add|retry|approve|remove|run")
+	}
+	q, err := openQueue(conf)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		jobs, err := q.List()
+		if err != nil {
+			return err
+		}
+		printJobs(jobs)
+	case "add":
+		for _, fileName := range args[1:] {
+			abs, err := filepath.Abs(fileName)
+			if err != nil {
+				return err
+			}
+			qj, err := q.Add(abs, nil)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d\t%s\t%s\n", qj.ID, qj.State, qj.File)
+		}
+	case "retry", "remove", "approve":
+		for _, arg := range args[1:] {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("bad job id %s", arg)
+			}
+			switch args[0] {
+			case "retry":
+				_, err = q.Retry(id)
+			case "approve":
+				_, err = q.Approve(id)
+			default:
+				err = q.Remove(id)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "job %d", id)
+			}
+		}
+	case "run":
+		fs := flag.NewFlagSet("queue run", flag.ExitOnError)
+		workers := fs.Int("jobs", conf.Queue.Workers, "Number of jobs to run at once")
+		untilEmpty := fs.Bool("until-empty", false, "Exit once no jobs are ready to run")
+		fs.Parse(args[1:])
+		if *workers < 1 {
+			*workers = 1
+		}
+		runner := &queueRunner{
+			Queue:      q,
+			Config:     conf,
+			Workers:    *workers,
+			Poll:       30 * time.Second,
+			UntilEmpty: *untilEmpty,
+		}
+		runner.Run(ctx, dispatchCtx)
+	default:
+		return fmt.Errorf("unknown queue command %s", args[0])
+	}
+	return nil
+}
+
+func printJobs(jobs []*jobqueue.Job) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tTRIES\tUPDATED\tFILE\tDETAIL")
+	for _, qj := range jobs {
+		detail := qj.Result
+		if qj.Error != "" {
+			detail = qj.Error
+		}
+		if qj.State == jobqueue.Pending && time.Until(qj.NotBefore) > 0 {
+			detail = "retry in " + time.Until(qj.NotBefore).Round(time.Second).String() + ": " + detail
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n", qj.ID, qj.State, qj.Attempts, qj.Updated.Local().Format("01-02 15:04"), qj.File, detail)
+	}
+	w.Flush()
+}
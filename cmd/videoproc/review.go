@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/comskip"
+	"github.com/crast/dvr-tools/mediainfo"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sheetTiles is the number of frames in a contact sheet, laid out 5x2.
+const sheetTiles = 10
+
+func runReview(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	outDir := fs.String("out", "", "Write bundles under this dir instead of next to each recording")
+	clipSeconds := fs.Float64("clip-seconds", 10, "Seconds of video on each side of a cut")
+	ini := fs.String("comskip-ini", "", "Comskip INI to use instead of the rule's")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no recordings to review")
+	}
+
+	failed := 0
+	for _, fileName := range fs.Args() {
+		if dispatchCtx.Err() != nil {
+			break
+		}
+		dir := stripExtension(fileName) + ".review"
+		if *outDir != "" {
+			dir = filepath.Join(*outDir, filepath.Base(stripExtension(fileName)))
+		}
+		if err := reviewFile(ctx, conf, fileName, dir, *clipSeconds, *ini); err != nil {
+			logrus.Errorf("could not review %s: %s", fileName, err.Error())
+			failed++
+			continue
+		}
+		fmt.Println(filepath.Join(dir, "index.html"))
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d reviews failed", failed, fs.NArg())
+	}
+	return nil
+}
+
+type reviewPage struct {
+	File       string
+	Duration   string
+	ComskipINI string
+	Cuts       []reviewCut
+}
+
+type reviewCut struct {
+	Number     int
+	Begin, End string
+	Length     string
+	Score      string
+	Boundaries []reviewBoundary
+}
+
+type reviewBoundary struct {
+	Name  string
+	At    string
+	Clip  string
+	Sheet string
+}
+
+// reviewFile detects the commercials in fileName as processing would, and
+// writes a clip and a contact sheet around each cut into dir, with an index.
+func reviewFile(ctx context.Context, conf *videoproc.Config, fileName, dir string, clipSeconds float64, ini string) error {
+	job := NewJob(conf, fileName)
+	if err := job.makeScratchDir(conf.General.ScratchDir); err != nil {
+		return err
+	}
+	defer job.DeleteFiles()
+
+	evaluators, err := videoproc.MakeEvaluators(conf.Rule)
+	if err != nil {
+		return errors.Wrap(err, "could not build evaluator")
+	}
+	info, err := mediainfo.Parse(ctx, fileName)
+	if err != nil {
+		return errors.Wrap(err, "could not parse mediainfo")
+	}
+	c, _, _ := buildEvalCtx(info, fileName)
+	decision, _, err := makeDecision(conf, evaluators, c, nil)
+	if err != nil {
+		return err
+	}
+	if ini != "" {
+		decision.ComskipINI = ini
+	}
+	commercials, err := detectCommercials(ctx, job, fileName, decision, c)
+	if err != nil {
+		return err
+	}
+	commercials = padCommercials(job, decision, commercials, c.DurationSec)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	page := reviewPage{
+		File:       filepath.Base(fileName),
+		Duration:   timestampMKV(c.DurationSec),
+		ComskipINI: decision.ComskipINI,
+	}
+	for i, com := range commercials {
+		cut := reviewCut{
+			Number: i + 1,
+			Begin:  timestampMKV(com.Begin),
+			End:    timestampMKV(com.End),
+			Length: fmt.Sprintf("%.1fs", com.Duration()),
+		}
+		if score, ok := comskip.Score(job.comskipBlocks, com); ok {
+			cut.Score = fmt.Sprintf("%.2f", score)
+		}
+		for _, b := range []struct {
+			name string
+			at   float64
+		}{{"start", com.Begin}, {"end", com.End}} {
+			if b.at <= 0 || b.at >= c.DurationSec-0.5 {
+				// The recording's own start or end isn't a cut.
+				continue
+			}
+			name := fmt.Sprintf("cut%02d-%s", i+1, b.name)
+			job.Log.Infof("Reviewing commercial %d %s at %s", i+1, b.name, timestampMKV(b.at))
+			if err := reviewClip(ctx, job, fileName, filepath.Join(dir, name+".mp4"), b.at, clipSeconds); err != nil {
+				return errors.Wrapf(err, "clip of %s", name)
+			}
+			if err := reviewSheet(ctx, job, fileName, filepath.Join(dir, name+".jpg"), b.at, clipSeconds); err != nil {
+				return errors.Wrapf(err, "contact sheet of %s", name)
+			}
+			cut.Boundaries = append(cut.Boundaries, reviewBoundary{b.name, timestampMKV(b.at), name + ".mp4", name + ".jpg"})
+		}
+		page.Cuts = append(page.Cuts, cut)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	if err := reviewTemplate.Execute(f, page); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reviewWindow returns where a window of clipSeconds either side of at
+// starts, and how long it is.
+func reviewWindow(at, clipSeconds float64) (start, length float64) {
+	start = math.Max(0, at-clipSeconds)
+	return start, at + clipSeconds - start
+}
+
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// reviewClip encodes a small, quick to load clip around a cut.
+func reviewClip(ctx context.Context, job *Job, fileName, clipFile string, at, clipSeconds float64) error {
+	start, length := reviewWindow(at, clipSeconds)
+	return job.runCommand(ctx, "ffmpeg", "-nostdin", "-y",
+		"-ss", formatSeconds(start), "-i", fileName, "-t", formatSeconds(length),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "yadif,scale=-2:360",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "28",
+		"-c:a", "aac", "-b:a", "96k",
+		"-movflags", "+faststart",
+		clipFile,
+	)
+}
+
+// reviewSheet tiles frames spread over the same window as reviewClip into
+// one image.
+func reviewSheet(ctx context.Context, job *Job, fileName, sheetFile string, at, clipSeconds float64) error {
+	start, length := reviewWindow(at, clipSeconds)
+	return job.runCommand(ctx, "ffmpeg", "-nostdin", "-y",
+		"-ss", formatSeconds(start), "-i", fileName, "-t", formatSeconds(length),
+		"-vf", fmt.Sprintf("fps=%.4f,yadif,scale=320:-2,tile=5x%d", sheetTiles/length, sheetTiles/5),
+		"-frames:v", "1",
+		sheetFile,
+	)
+}
+
+var reviewTemplate = template.Must(template.New("review").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Review: {{.File}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.boundary { display: inline-block; vertical-align: top; margin: 0 1em 1em 0; }
+img, video { display: block; max-width: 640px; }
+</style>
+</head>
+<body>
+<h1>{{.File}}</h1>
+<p>Duration {{.Duration}}{{if .ComskipINI}}, comskip INI {{.ComskipINI}}{{end}}</p>
+{{if .Cuts}}
+<table>
+<tr><th>#</th><th>Start</th><th>End</th><th>Length</th><th>Score</th></tr>
+{{range .Cuts}}<tr><td><a href="#cut{{.Number}}">{{.Number}}</a></td><td>{{.Begin}}</td><td>{{.End}}</td><td>{{.Length}}</td><td>{{.Score}}</td></tr>
+{{end}}</table>
+{{range .Cuts}}
+<h2 id="cut{{.Number}}">Commercial {{.Number}}: {{.Begin}} to {{.End}}</h2>
+{{range .Boundaries}}<div class="boundary">
+<h3>{{.Name}} at {{.At}}</h3>
+<video controls preload="none" src="{{.Clip}}"></video>
+<a href="{{.Sheet}}"><img src="{{.Sheet}}" alt="frames around the {{.Name}}"></a>
+</div>
+{{end}}{{end}}
+{{else}}
+<p>No commercials were found.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/cutlist"
+)
+
+// heldError holds a job for someone to review, rather than failing it.
+type heldError struct {
+	problems []string
+}
+
+func (e heldError) Error() string {
+	return "held for review: " + strings.Join(e.problems, "; ")
+}
+
+func isHeld(err error) bool {
+	var held heldError
+	return errors.As(err, &held)
+}
+
+// checkLimits tidies away commercials too short to trust and checks the rest
+// against the rule's limits. ok is false when they break one and chapters
+// should be marked instead of cutting. With the "hold" action, a heldError is
+// returned instead.
+func checkLimits(job *Job, limits *videoproc.CommercialLimits, commercials []Commercial, duration float64, chapterMode bool) (tidied []Commercial, ok bool, err error) {
+	l := cutlist.Limits{
+		MinCommercial: limits.MinCommercial,
+		MaxCommercial: limits.MaxCommercial,
+		MinSegment:    limits.MinSegment,
+		MaxFraction:   limits.MaxFraction,
+		MaxCount:      limits.MaxCount,
+	}
+	tidied = l.Tidy(commercials)
+	if len(tidied) != len(commercials) {
+		job.Log.Infof("Tidied %d commercials into %d", len(commercials), len(tidied))
+	}
+	if chapterMode {
+		// Nothing is cut, so nothing can be lost.
+		return tidied, true, nil
+	}
+	problems := l.Check(tidied, duration)
+	if len(problems) == 0 {
+		return tidied, true, nil
+	}
+	if job.Overrides != nil && job.Overrides.IgnoreLimits {
+		job.Log.Warnf("Cutting despite limits: %s", strings.Join(problems, "; "))
+		return tidied, true, nil
+	}
+	switch limits.Action {
+	case "", "chapter":
+		for _, p := range problems {
+			job.Log.Warnf("Marking chapters instead of cutting: %s", p)
+		}
+		return tidied, false, nil
+	case "hold":
+		return nil, false, heldError{problems}
+	default:
+		return nil, false, fmt.Errorf("unknown limits action %q", limits.Action)
+	}
+}
+
+// mergeLimits sets the limits given in a rule over the decision's.
+func mergeLimits(decision *videoproc.Rule, limits videoproc.CommercialLimits) {
+	if decision.Limits == nil {
+		decision.Limits = &videoproc.CommercialLimits{}
+	}
+	d := decision.Limits
+	takeFloat(&d.MinCommercial, limits.MinCommercial)
+	takeFloat(&d.MaxCommercial, limits.MaxCommercial)
+	takeFloat(&d.MinSegment, limits.MinSegment)
+	takeFloat(&d.MaxFraction, limits.MaxFraction)
+	if limits.MaxCount != 0 {
+		d.MaxCount = limits.MaxCount
+	}
+	takeString(&d.Action, limits.Action)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/jobqueue"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func runServe(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) error {
+	listen := conf.Serve.Listen
+	if listen == "" {
+		listen = "127.0.0.1:8082"
+	}
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&listen, "listen", listen, "Address to listen on")
+	workers := fs.Int("jobs", conf.Serve.Workers, "Number of jobs to run at once")
+	fs.Parse(args)
+	if *workers < 1 {
+		*workers = 1
+	}
+	if conf.Serve.Token == "" && !isLoopback(listen) {
+		return fmt.Errorf("serve: listening on %s needs a [serve] token", listen)
+	}
+
+	q, err := openQueue(conf)
+	if err != nil {
+		return err
+	}
+
+	api := &jobAPI{Config: conf, Queue: q}
+	server := &http.Server{Handler: requireToken(conf.Serve.Token, api.Router())}
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := server.Serve(lis); err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
+	}()
+	logrus.Infof("serve: accepting jobs on %s", listen)
+
+	runner := &queueRunner{
+		Queue:   q,
+		Config:  conf,
+		Workers: *workers,
+		Poll:    5 * time.Second,
+	}
+	runner.Run(ctx, dispatchCtx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// isLoopback reports whether a listen address only accepts local
+// connections.
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireToken turns away requests without the bearer token, when one is
+// set.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or bad token")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// jobAPI lets a DVR submit finished recordings and check on their jobs.
+//
+//	POST /jobs               {"path": "...", "rules": [...], "profile": "...", "comskip": "..."}
+//	GET  /jobs               all jobs
+//	GET  /jobs/{id}          one job
+//	GET  /jobs/{id}/log      the job's log lines, ?tail=N for only the last N
+//
+// POST /jobs also accepts the same fields as form values (rule may repeat),
+// for DVR post-processing scripts that just use curl.
+type jobAPI struct {
+	Config *videoproc.Config
+	Queue  *jobqueue.Queue
+}
+
+type jobRequest struct {
+	Path string `json:"path"`
+	videoproc.Overrides
+}
+
+func (api *jobAPI) Router() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", api.listJobs).Methods("GET")
+	r.HandleFunc("/jobs", api.submitJob).Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}", api.jobRoute(api.getJob)).Methods("GET")
+	r.HandleFunc("/jobs/{id:[0-9]+}/log", api.jobRoute(api.getJobLog)).Methods("GET")
+	return r
+}
+
+func (api *jobAPI) submitJob(w http.ResponseWriter, req *http.Request) {
+	var jr jobRequest
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(req.Body).Decode(&jr); err != nil {
+			writeError(w, http.StatusBadRequest, "bad JSON: "+err.Error())
+			return
+		}
+	} else {
+		req.ParseForm()
+		jr.Path = req.Form.Get("path")
+		jr.Rules = req.Form["rule"]
+		jr.Profile = req.Form.Get("profile")
+		jr.Comskip = req.Form.Get("comskip")
+	}
+
+	if jr.Path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	fileName := flipPath(api.Config.General.FlipDirs, jr.Path)
+	if !filepath.IsAbs(fileName) {
+		writeError(w, http.StatusBadRequest, "path must be absolute")
+		return
+	}
+	if _, err := os.Stat(fileName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, label := range jr.Rules {
+		if findRule(api.Config, label) == nil {
+			writeError(w, http.StatusBadRequest, "no rule labeled "+label)
+			return
+		}
+	}
+	if jr.Profile != "" && findProfile(api.Config, jr.Profile) == nil {
+		writeError(w, http.StatusBadRequest, "no profile named "+jr.Profile)
+		return
+	}
+
+	var overrides *videoproc.Overrides
+	if len(jr.Rules) != 0 || jr.Profile != "" || jr.Comskip != "" {
+		overrides = &jr.Overrides
+	}
+	qj, err := api.Queue.Add(fileName, overrides)
+	if err == jobqueue.ErrConflict {
+		writeError(w, http.StatusConflict, fmt.Sprintf("%s already has job %d (%s) with other overrides", fileName, qj.ID, qj.State))
+		return
+	}
+	if err == nil && qj.State == jobqueue.Failed {
+		// Resubmitting a failed recording is a request to try again.
+		qj, err = api.Queue.Retry(qj.ID)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logrus.Infof("serve: queued %s as job %d", fileName, qj.ID)
+	writeJSON(w, http.StatusAccepted, qj)
+}
+
+func (api *jobAPI) listJobs(w http.ResponseWriter, req *http.Request) {
+	jobs, err := api.Queue.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if jobs == nil {
+		jobs = []*jobqueue.Job{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (api *jobAPI) getJob(w http.ResponseWriter, req *http.Request, qj *jobqueue.Job) {
+	writeJSON(w, http.StatusOK, qj)
+}
+
+func (api *jobAPI) getJobLog(w http.ResponseWriter, req *http.Request, qj *jobqueue.Job) {
+	lines := []string{}
+	f, err := os.Open(api.Queue.LogFile(qj.ID))
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tail, err := strconv.Atoi(req.URL.Query().Get("tail")); err == nil && tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":    qj.ID,
+		"state": qj.State,
+		"log":   lines,
+	})
+}
+
+func (api *jobAPI) jobRoute(f func(http.ResponseWriter, *http.Request, *jobqueue.Job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, _ := strconv.Atoi(mux.Vars(req)["id"])
+		qj, err := api.Queue.Get(id)
+		if err == jobqueue.ErrNotFound {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("job %d not found", id))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		f(w, req, qj)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		logrus.Warnf("serve: could not write response: %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// flipPath maps a path as another application sees it to our view of it,
+// using the longest matching directory in flipDirs.
+func flipPath(flipDirs map[string]string, path string) string {
+	best := ""
+	for from := range flipDirs {
+		if (path == from || strings.HasPrefix(path, strings.TrimSuffix(from, "/")+"/")) && len(from) > len(best) {
+			best = from
+		}
+	}
+	if best == "" {
+		return path
+	}
+	return filepath.Join(flipDirs[best], strings.TrimPrefix(path, best))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/keyframes"
+	"github.com/crast/dvr-tools/internal/smartcut"
+	"github.com/pkg/errors"
+)
+
+// performSmartCut cuts the kept chapters out frame accurately, re-encoding
+// only the partial GOPs at each cut with settings matching the source and
+// stream copying the rest. Like performTrackSplit, it returns a concat list
+// of the parts.
+func performSmartCut(ctx context.Context, job *Job, fileName string, chapters []Chapter) (string, error) {
+	idx, err := keyframes.Probe(ctx, fileName)
+	if err != nil {
+		return "", errors.Wrap(err, "could not index keyframes")
+	}
+	video, err := smartcut.ProbeVideo(ctx, fileName)
+	if err != nil {
+		return "", errors.Wrap(err, "could not probe video")
+	}
+	encodeArgs, err := smartcut.EncoderArgs(video)
+	if err != nil {
+		return "", err
+	}
+
+	var kept []cutlist.Range
+	for _, c := range chapters {
+		kept = append(kept, cutlist.Range{Begin: c.Begin, End: c.End})
+	}
+	parts := smartcut.Plan(kept, idx)
+
+	var buf bytes.Buffer
+	var encoded float64
+	for i, part := range parts {
+		partFile := job.ScratchFile(fmt.Sprintf("tmp%d.ts", i))
+		job.TrackFile(partFile, false)
+		params := []string{
+			"-nostdin",
+			"-ss", strconv.FormatFloat(part.Begin, 'f', -1, 64),
+			"-i", fileName,
+			"-t", strconv.FormatFloat(part.Duration(), 'f', -1, 64),
+			"-map", "0:v:0", "-map", "0:a?",
+			"-map_chapters", "-1",
+		}
+		if part.Encode {
+			job.Log.Infof("Smart cut part %d: encode %s-%s", i+1, timestampMKV(part.Begin), timestampMKV(part.End))
+			params = append(params, encodeArgs...)
+			params = append(params, "-c:a", "copy")
+			encoded += part.Duration()
+		} else {
+			job.Log.Infof("Smart cut part %d: copy %s-%s", i+1, timestampMKV(part.Begin), timestampMKV(part.End))
+			params = append(params, "-c", "copy", "-avoid_negative_ts", "make_zero")
+		}
+		params = append(params, partFile)
+		if err := job.runCommand(ctx, "ffmpeg", params...); err != nil {
+			return "", errors.Wrapf(err, "smart cut part %d", i+1)
+		}
+		fmt.Fprintf(&buf, "file '%s'\n", partFile)
+	}
+	job.Log.Infof("Smart cut re-encoded %.1fs in %d parts", encoded, len(parts))
+
+	textFile := job.ScratchFile("fpart.txt")
+	ioutil.WriteFile(textFile, buf.Bytes(), 0666)
+	job.TrackFile(textFile, true)
+	return textFile, nil
+}
+
+// checkSmartCut decodes a smart cut's output in full and fails if ffmpeg
+// reports any errors. The re-encoded parts carry their own parameter sets,
+// but the output only has room for one, so some sources end up with joins
+// that players can't decode.
+func checkSmartCut(ctx context.Context, job *Job, fileName string) error {
+	job.Log.Info("Checking that the smart cut decodes")
+	release, err := acquireTool(ctx, "ffmpeg")
+	if err != nil {
+		return err
+	}
+	defer release()
+	stderr := &tailBuffer{Max: 4096}
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-nostdin", "-v", "error", "-i", fileName, "-f", "null", "-")
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "could not decode smart cut")
+	}
+	if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+		first := bytes.SplitN(msg, []byte("\n"), 2)[0]
+		return fmt.Errorf("smart cut doesn't decode cleanly, use --chop-files for this file: %s", first)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/keyframes"
+	"github.com/pkg/errors"
+)
+
+// snapChapters moves the boundaries of the kept chapters to keyframes by the
+// policy, so each part of a stream copy starts on one, and logs every move.
+// Parts that snapping runs together are joined, and ones it empties are
+// dropped.
+func snapChapters(ctx context.Context, job *Job, fileName string, chapters []Chapter, policy string, duration float64) ([]Chapter, error) {
+	idx, err := keyframes.Probe(ctx, fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not index keyframes")
+	}
+	job.Log.Debugf("Found %d keyframes", len(idx))
+
+	var out []Chapter
+	for i, c := range chapters {
+		r := idx.Snap(cutlist.Range{Begin: c.Begin, End: c.End}, policy)
+		if c.End >= duration-0.5 {
+			// Nothing follows the end of the recording.
+			r.End = c.End
+		}
+		if r != (cutlist.Range{Begin: c.Begin, End: c.End}) {
+			job.Log.Infof("Snapped part %d (%s) %s-%s to %s-%s, %+.3fs/%+.3fs",
+				i+1, policy, timestampMKV(c.Begin), timestampMKV(c.End), timestampMKV(r.Begin), timestampMKV(r.End),
+				r.Begin-c.Begin, r.End-c.End)
+		}
+		if r.End <= r.Begin {
+			job.Log.Warnf("Dropping part %d, which snapping left empty", i+1)
+			continue
+		}
+		if n := len(out); n != 0 && r.Begin <= out[n-1].End {
+			job.Log.Infof("Joining part %d to the one before it", i+1)
+			if r.End > out[n-1].End {
+				out[n-1].End = r.End
+			}
+			continue
+		}
+		c.Begin, c.End = r.Begin, r.End
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/fileio"
+	"github.com/pkg/errors"
+)
+
+// spaceEstimate is roughly how many bytes each stage of a job writes.
+type spaceEstimate struct {
+	// Split is the parts written by a track split.
+	Split int64
+	// Output is the final ffmpeg output, which is then moved next to the original.
+	Output int64
+}
+
+// Scratch is the most scratch space the job uses at once.
+func (e spaceEstimate) Scratch() int64 {
+	return e.Split + e.Output
+}
+
+// estimateSpace works out each stage's size from the input size, the share of
+// its duration that is kept and the encoding profile.
+func estimateSpace(fileSize int64, duration, keptDuration float64, decision *videoproc.Rule) spaceEstimate {
+	kept := 1.0
+	if duration > 0 && keptDuration > 0 && keptDuration < duration {
+		kept = keptDuration / duration
+	}
+	keptSize := int64(float64(fileSize) * kept)
+
+	var e spaceEstimate
+	if slapChop {
+		e.Split = keptSize
+	}
+	e.Output = int64(float64(keptSize) * encodeRatio(decision.Encode))
+	return e
+}
+
+// encodeRatio guesses the output size relative to the input for a profile.
+// Guesses err on the large side, as running out of space costs more than
+// waiting a little longer for it.
+func encodeRatio(enc videoproc.EncodeConfig) float64 {
+	codec := strings.ToLower(enc.Video.Codec)
+	switch {
+	case codec == "" || codec == "copy":
+		return 1.05
+	case strings.Contains(codec, "265") || strings.Contains(codec, "hevc") || strings.Contains(codec, "av1") || strings.Contains(codec, "vp9"):
+		return 0.6
+	case strings.Contains(codec, "264"):
+		return 0.8
+	default:
+		return 1.05
+	}
+}
+
+type spaceError struct {
+	Stage string
+	Dir   string
+	Need  int64
+	Free  int64
+}
+
+func (e *spaceError) Error() string {
+	return fmt.Sprintf("not enough space for %s: %s needs %s but has %s free", e.Stage, e.Dir, formatBytes(e.Need), formatBytes(e.Free))
+}
+
+// ensureSpace checks that the scratch dir has room for scratchNeed bytes and
+// destFile's filesystem for destNeed, before a stage starts writing. When the
+// scratch dir is short it switches the job to the first of the alternate
+// scratch dirs with room. If nothing fits, it waits up to [space]
+// wait-minutes for space to free up and then fails with a transient error,
+// so queued jobs are retried later.
+func (job *Job) ensureSpace(ctx context.Context, stage string, scratchNeed, destNeed int64, destFile string) error {
+	sc := job.Config.Space
+	reserve := int64(sc.ReserveMB) << 20
+	if sc.ReserveMB == 0 {
+		reserve = 512 << 20
+	}
+	deadline := time.Now().Add(time.Duration(sc.WaitMinutes) * time.Minute)
+	destDir := filepath.Dir(destFile)
+	for {
+		err := job.checkSpace(stage, scratchNeed+reserve, destNeed+reserve, destDir)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*spaceError); !ok {
+			// A missing or unusable dir won't be fixed by waiting.
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return transient(err)
+		}
+		job.Log.Warnf("%s, waiting for space", err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (job *Job) checkSpace(stage string, scratchNeed, destNeed int64, destDir string) error {
+	current := filepath.Dir(job.ScratchDir())
+	candidates := append([]string{current}, job.Config.Space.ScratchDirs...)
+	var chosen string
+	var short error
+	for _, dir := range candidates {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			job.Log.Warnf("skipping scratch dir %s: %s", dir, err.Error())
+			continue
+		}
+		free, err := fileio.FreeSpace(dir)
+		if err != nil {
+			job.Log.Warnf("skipping scratch dir %s: %s", dir, err.Error())
+			continue
+		}
+		if free < scratchNeed {
+			if short == nil {
+				short = &spaceError{stage, dir, scratchNeed, free}
+			}
+			continue
+		}
+		chosen = dir
+		break
+	}
+	if chosen == "" {
+		if short == nil {
+			short = errors.New("no usable scratch dir")
+		}
+		return short
+	}
+	if chosen != current {
+		job.Log.Warnf("%s is short on space for %s, using %s instead", current, stage, chosen)
+		if err := job.makeScratchDir(chosen); err != nil {
+			return err
+		}
+	}
+
+	// On the same filesystem the output is renamed into place, which takes
+	// no more space than it already does in scratch.
+	if fileio.SameFilesystem(chosen, destDir) {
+		return nil
+	}
+	free, err := fileio.FreeSpace(destDir)
+	if err != nil {
+		return err
+	}
+	if free < destNeed {
+		return &spaceError{stage, destDir, destNeed, free}
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func chaptersDuration(chapters []Chapter) float64 {
+	var total float64
+	for _, c := range chapters {
+		total += c.End - c.Begin
+	}
+	return total
+}
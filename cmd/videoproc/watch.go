@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crast/dvr-tools"
+	"github.com/sirupsen/logrus"
+)
+
+func runWatch(ctx, dispatchCtx context.Context, conf *videoproc.Config, args []string) (err error) {
+	wc := conf.Watch
+	if wc.StableMinutes <= 0 {
+		wc.StableMinutes = 5
+	}
+	if wc.PollSeconds <= 0 {
+		wc.PollSeconds = 60
+	}
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	stableMinutes := fs.Float64("stable", wc.StableMinutes, "Minutes a recording must stop changing before it's processed")
+	pollSeconds := fs.Int("poll", wc.PollSeconds, "Seconds between directory scans")
+	workers := fs.Int("jobs", wc.Workers, "Number of files to process at once")
+	fs.Parse(args)
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		dirs = wc.Dirs
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("watch: no directories given on the command line or in [watch] config")
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+	extensions := wc.Extensions
+	if len(extensions) == 0 {
+		extensions = []string{".ts"}
+	}
+
+	for i, dir := range dirs {
+		if dirs[i], err = filepath.Abs(dir); err != nil {
+			return err
+		}
+	}
+
+	w := &watcher{
+		Dirs:       dirs,
+		Extensions: extensions,
+		Exclude:    append(append([]string{}, wc.Exclude...), defaultExcludes...),
+		StableFor:  time.Duration(*stableMinutes * float64(time.Minute)),
+		files:      map[string]*watchedFile{},
+	}
+
+	q, err := openQueue(conf)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("watch: watching %v for finished recordings", dirs)
+	runner := &queueRunner{
+		Queue:   q,
+		Config:  conf,
+		Workers: *workers,
+		Poll:    time.Duration(*pollSeconds) * time.Second,
+		BeforeClaim: func() {
+			for _, fileName := range w.Scan(ctx, time.Now()) {
+				qj, err := q.Add(fileName, nil)
+				if err != nil {
+					logrus.Errorf("watch: could not queue %s: %s", fileName, err.Error())
+					continue
+				}
+				logrus.Infof("watch: %s has finished recording, queued as job %d (%s)", fileName, qj.ID, qj.State)
+			}
+		},
+	}
+	runner.Run(ctx, dispatchCtx)
+	return nil
+}
+
+type watcher struct {
+	Dirs       []string
+	Extensions []string
+	Exclude    []string
+	StableFor  time.Duration
+
+	files map[string]*watchedFile
+}
+
+type watchedFile struct {
+	Size    int64
+	ModTime time.Time
+	// Since is when this size and mtime were first observed.
+	Since time.Time
+	// Handled is set once the file was queued or found to need no work.
+	// A change in size or mtime clears it.
+	Handled bool
+}
+
+// Scan walks the watched directories and returns files that are newly ready
+// to process: size and mtime unchanged across scans and for at least StableFor.
+func (w *watcher) Scan(ctx context.Context, now time.Time) []string {
+	var ready []string
+	present := map[string]bool{}
+	for _, dir := range w.Dirs {
+		err := filepath.Walk(dir, func(fileName string, info os.FileInfo, err error) error {
+			if err != nil {
+				logrus.Warnf("watch: %s", err.Error())
+				return nil
+			}
+			if !info.Mode().IsRegular() || !hasExtension(fileName, w.Extensions) || matchesAny(fileName, w.Exclude) {
+				return nil
+			}
+			present[fileName] = true
+			if w.ready(ctx, fileName, info, now) {
+				ready = append(ready, fileName)
+			}
+			return nil
+		})
+		if err != nil {
+			logrus.Warnf("watch: scanning %s: %s", dir, err.Error())
+		}
+	}
+	for fileName := range w.files {
+		if !present[fileName] {
+			delete(w.files, fileName)
+		}
+	}
+	return ready
+}
+
+func (w *watcher) ready(ctx context.Context, fileName string, info os.FileInfo, now time.Time) bool {
+	entry := w.files[fileName]
+	if entry == nil || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		w.files[fileName] = &watchedFile{Size: info.Size(), ModTime: info.ModTime(), Since: now}
+		return false
+	}
+	if entry.Handled || now.Sub(entry.ModTime) < w.StableFor || !entry.Since.Before(now) {
+		return false
+	}
+	if mediaFileLocked(fileName) {
+		logrus.Debugf("watch: %s is locked, checking again later", fileName)
+		return false
+	}
+	entry.Handled = true
+	if outputAlreadyProcessed(ctx, fileName) {
+		logrus.Debugf("watch: %s was already processed", fileName)
+		return false
+	}
+	return true
+}
+
+// outputAlreadyProcessed reports whether the mkv that processing fileName
+// would produce already exists next to it, carrying videoproc's tags. A file
+// that is itself tagged is left to processVideo, which knows whether the
+// decision changed.
+func outputAlreadyProcessed(ctx context.Context, fileName string) bool {
+	state, destFile, err := outputState(ctx, fileName)
+	if err != nil {
+		logrus.Warnf("watch: could not check %s: %s", destFile, err.Error())
+		return false
+	}
+	return state.Version != ""
+}
@@ -12,10 +12,19 @@ func ParseConfig(filename string) (*Config, error) {
 
 type Config struct {
 	General GeneralConfig
+	Batch   BatchConfig
+	Watch   WatchConfig
+	Queue   QueueConfig
+	Serve   ServeConfig
+	Lock    LockConfig
+	Space   SpaceConfig
+	Backup  BackupConfig
 	Profile []EncodeConfig
 	Rule    []Rule
 }
 
+// Rule fields added over time are omitted from JSON when unset, so the
+// decision hashes of files processed before they existed don't change.
 type Rule struct {
 	Label      string
 	Match      string
@@ -23,16 +32,168 @@ type Rule struct {
 	ComskipINI string `toml:"comskip-ini"`
 	Actions    []string
 
+	// Detectors are the sources of commercial breaks to use, by name, and
+	// DetectorMerge how to combine what they find.
+	Detectors      []string `json:",omitempty"`
+	DetectorMerge  string   `toml:"detector-merge" json:",omitempty"`
+	DetectorScript string   `toml:"detector-script" json:",omitempty"`
+
+	// ComskipCutScore is the comskip block score a commercial needs to be
+	// cut; ones below it are only marked with chapters. If less than
+	// ComskipMinConfidence of the commercial time clears it, nothing is cut
+	// and chapter mode is used instead.
+	ComskipCutScore      float64 `toml:"comskip-cut-score" json:",omitempty"`
+	ComskipMinConfidence float64 `toml:"comskip-min-confidence" json:",omitempty"`
+
+	// KeyframeSnap is how to move the cut points of --chop-files to
+	// keyframes: nearest, keep-more or keep-less. By default they're left
+	// where they are.
+	KeyframeSnap string `toml:"keyframe-snap" json:",omitempty"`
+
+	// PaddingBegin is seconds of show to keep before each segment begins,
+	// and PaddingEnd after each one ends; negative padding cuts into the
+	// show. Rounding moves cut points to whole seconds: nearest, keep-more
+	// or keep-less.
+	PaddingBegin float64 `toml:"padding-begin" json:",omitempty"`
+	PaddingEnd   float64 `toml:"padding-end" json:",omitempty"`
+	Rounding     string  `json:",omitempty"`
+
+	// Limits are sanity checks on the commercials found before any are cut.
+	Limits *CommercialLimits `json:",omitempty"`
+
+	// ChapterEditions writes chapter mode's chapters as a default ordered
+	// edition that skips the commercials, plus a full recording edition.
+	ChapterEditions bool `toml:"chapter-editions" json:",omitempty"`
+
+	// Exports are the sidecar formats to write the chapters in, next to
+	// the output, in chapter mode.
+	Exports []string `json:",omitempty"`
+
 	MatchShows []string `toml:"match-shows"`
 
 	Profile string
 	Encode  EncodeConfig
 }
 
+// CommercialLimits are in seconds, apart from MaxFraction, the most of a
+// recording that may be commercials, and MaxCount. Commercials shorter than
+// MinCommercial are joined to a neighbor less than MinSegment away, or
+// dropped. When the rest break a limit, Action says what to do: "chapter"
+// (the default) marks chapters instead of cutting, and "hold" holds the job
+// for review.
+type CommercialLimits struct {
+	MinCommercial float64 `toml:"min-commercial"`
+	MaxCommercial float64 `toml:"max-commercial"`
+	MinSegment    float64 `toml:"min-segment"`
+	MaxFraction   float64 `toml:"max-fraction"`
+	MaxCount      int     `toml:"max-count"`
+	Action        string
+}
+
 type GeneralConfig struct {
 	ScratchDir  string `toml:"scratch-dir"`
 	WatchLogDir string `toml:"watch-log-dir"`
-	RoundCuts   bool   `toml:"round-cuts"`
+	// RoundCuts is the old way to round keep-more, for rules without
+	// their own rounding.
+	RoundCuts bool `toml:"round-cuts"`
+	// SweepMinutes is how long scratch left by a videoproc run that died
+	// is kept before it's removed. It defaults to an hour.
+	SweepMinutes int `toml:"sweep-minutes"`
+	// VerifyCopies checksums files copied between filesystems before the
+	// source is removed.
+	VerifyCopies bool `toml:"verify-copies"`
+
+	// FlipDirs maps directories as other applications (e.g. a DVR in a
+	// container) see them to the same directories as seen by videoproc.
+	FlipDirs map[string]string `toml:"flipdirs"`
+}
+
+type BatchConfig struct {
+	Workers      int
+	ComskipLimit int `toml:"comskip-limit"`
+	FFmpegLimit  int `toml:"ffmpeg-limit"`
+
+	// Extensions of media files to pick up when walking directories.
+	Extensions []string
+	// Include and Exclude are glob patterns matched against the file's base name and full path.
+	Include []string
+	Exclude []string
+}
+
+type WatchConfig struct {
+	// Dirs are the recording directories to watch.
+	Dirs []string
+	// StableMinutes is how long a file's size and mtime must stay the same
+	// before the recording is considered finished.
+	StableMinutes float64 `toml:"stable-minutes"`
+	PollSeconds   int     `toml:"poll-seconds"`
+	Workers       int
+	Extensions    []string
+	Exclude       []string
+}
+
+type QueueConfig struct {
+	// Dir holds the job queue. It defaults to "queue" inside the scratch dir.
+	Dir     string
+	Workers int
+	// MaxAttempts is how many times a job with transient failures is tried
+	// before it's parked as failed.
+	MaxAttempts int `toml:"max-attempts"`
+	// RetryMinutes is the first retry delay, doubled on each further failure
+	// up to MaxRetryMinutes.
+	RetryMinutes    int `toml:"retry-minutes"`
+	MaxRetryMinutes int `toml:"max-retry-minutes"`
+}
+
+type ServeConfig struct {
+	// Listen defaults to 127.0.0.1:8082. Listening on any other address
+	// needs a Token, which requests must then send as a bearer token.
+	Listen  string
+	Token   string
+	Workers int
+}
+
+// LockConfig limits how many single-file runs, such as those started by a
+// DVR's post-processing hook, process at once across processes.
+type LockConfig struct {
+	// Dir holds the slot files. Setting it turns the limit on.
+	Dir   string
+	Slots int
+	// TimeoutMinutes is how long to wait for a slot before handing the file
+	// to the job queue instead. It defaults to an hour.
+	TimeoutMinutes int `toml:"timeout-minutes"`
+}
+
+// SpaceConfig controls the free space checks made before each stage that
+// writes large files.
+type SpaceConfig struct {
+	// ScratchDirs are tried in order when the scratch dir is short on space.
+	ScratchDirs []string `toml:"scratch-dirs"`
+	// ReserveMB is kept free on every filesystem on top of the estimate.
+	ReserveMB int `toml:"reserve-mb"`
+	// WaitMinutes is how long to wait for space to free up before failing.
+	// Zero fails right away.
+	WaitMinutes int `toml:"wait-minutes"`
+}
+
+// BackupConfig is where originals go when the processed file replaces them,
+// and how long they are kept.
+type BackupConfig struct {
+	// Dir keeps originals under their full path. Without it they are left
+	// next to the processed file as backup.orig.<name>.
+	Dir        string
+	MaxAgeDays int `toml:"max-age-days"`
+	MaxSizeGB  int `toml:"max-size-gb"`
+}
+
+// Overrides adjust the decision for a single job, on top of the matched rules.
+type Overrides struct {
+	// Rules are labels of rules to apply even if they don't match.
+	Rules   []string `json:"rules,omitempty"`
+	Profile string   `json:"profile,omitempty"`
+	Comskip string   `json:"comskip,omitempty"`
+	// IgnoreLimits cuts even when the commercials break the rule's limits.
+	IgnoreLimits bool `json:"ignoreLimits,omitempty"`
 }
 
 type EncodeConfig struct {
@@ -74,6 +74,7 @@ type VideoCtx struct {
 	FormatVersion string
 	FormatProfile string
 	ScanType      string
+	FrameRate     float64
 	Extra         map[string]string
 }
 
@@ -9,10 +9,8 @@ require (
 	github.com/nightlyone/lockfile v1.0.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.5.0
+	golang.org/x/sys v0.0.0-20200327173247-9dae0f8f5775
 	gopkg.in/crast/app.v0 v0.0.0-20170720005637-cbe1cedf7472
 )
 
-require (
-	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
-	golang.org/x/sys v0.0.0-20200327173247-9dae0f8f5775 // indirect
-)
+require github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
@@ -0,0 +1,215 @@
+// Package backup keeps the originals of processed files out of the media
+// folders, and prunes them by age and total size.
+//
+// A Store mirrors the absolute path of each original under its dir, so
+// /dvr/TV/Show/ep.ts is kept as <dir>/dvr/TV/Show/ep.ts. If that name is
+// taken, the later backup gets a .<unix nanoseconds> suffix; the plain name
+// always holds the earliest original.
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/crast/dvr-tools/internal/fileio"
+)
+
+// LegacyPrefix is how originals were backed up next to the processed file
+// before there was a backup dir.
+const LegacyPrefix = "backup.orig."
+
+type Entry struct {
+	Path     string
+	Original string
+	Size     int64
+	// Saved is when the file was moved into the backup.
+	Saved time.Time
+}
+
+type Store struct {
+	Dir string
+}
+
+// Path is where the backup of fileName goes.
+func (s *Store) Path(fileName string) (string, error) {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, abs), nil
+}
+
+// Save moves fileName into the backup and returns its new path.
+func (s *Store) Save(ctx context.Context, fileName string) (string, error) {
+	dest, err := s.Path(fileName)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return "", errors.Wrap(err, "create backup dir")
+	}
+	dest, err = reserve(dest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fileio.Move(ctx, fileName, dest); err != nil {
+		os.Remove(dest)
+		return "", errors.Wrap(err, "move to backup")
+	}
+	return dest, nil
+}
+
+// reserve creates an empty file at dest, or at dest with a nanosecond
+// suffix when that's taken, so two backups of one path never overwrite each
+// other. It returns the path reserved.
+func reserve(dest string) (string, error) {
+	path := dest
+	for tries := 0; tries < 100; tries++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return path, f.Close()
+		}
+		if !os.IsExist(err) {
+			return "", errors.Wrap(err, "reserve backup name")
+		}
+		path = dest + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return "", errors.Errorf("no free backup name for %s", dest)
+}
+
+// Find returns the backup for name, which is either the original's path or
+// a path inside the backup dir.
+func (s *Store) Find(name string) (*Entry, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, _ := filepath.Abs(s.Dir)
+	path := abs
+	if !strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+		path = filepath.Join(dir, abs)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no backup of %s", name)
+	}
+	return s.entry(path, info), nil
+}
+
+// Restore moves a backup back to where the original was. An existing file
+// there is only replaced when overwrite is set.
+func (s *Store) Restore(ctx context.Context, e *Entry, overwrite bool) error {
+	if _, err := os.Lstat(e.Original); err == nil && !overwrite {
+		return errors.Errorf("%s already exists", e.Original)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Original), 0777); err != nil {
+		return err
+	}
+	_, err := fileio.Move(ctx, e.Path, e.Original)
+	return err
+}
+
+// List returns every backup in the store.
+func (s *Store) List() ([]Entry, error) {
+	var entries []Entry
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			entries = append(entries, *s.entry(path, info))
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (s *Store) entry(path string, info os.FileInfo) *Entry {
+	dir, _ := filepath.Abs(s.Dir)
+	original := string(filepath.Separator) + strings.TrimPrefix(path, dir+string(filepath.Separator))
+	// Suffixes are nanoseconds, or seconds for older backups.
+	if ext := filepath.Ext(original); len(ext) == 20 || len(ext) == 11 {
+		if _, err := strconv.ParseInt(ext[1:], 10, 64); err == nil {
+			original = strings.TrimSuffix(original, ext)
+		}
+	}
+	return &Entry{Path: path, Original: original, Size: info.Size(), Saved: changeTime(info)}
+}
+
+// Prune removes the store's backups past the retention limits, as Expired
+// picks them, apart from keep. It returns the ones removed.
+func (s *Store) Prune(maxAge time.Duration, maxSize int64, keep string) ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []Entry
+	for _, e := range Expired(entries, maxAge, maxSize, time.Now()) {
+		if e.Path == keep {
+			continue
+		}
+		// Another job may be pruning too.
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// LegacyBackups finds backup.orig.* files left next to processed files in dirs.
+func LegacyBackups(dirs []string) ([]Entry, error) {
+	var entries []Entry
+	for _, root := range dirs {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), LegacyPrefix) {
+				entries = append(entries, Entry{
+					Path:     path,
+					Original: filepath.Join(filepath.Dir(path), strings.TrimPrefix(info.Name(), LegacyPrefix)),
+					Size:     info.Size(),
+					Saved:    changeTime(info),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Expired picks the entries to remove so that none is older than maxAge and
+// their total size is at most maxSize, oldest first. Zero disables a limit.
+func Expired(entries []Entry, maxAge time.Duration, maxSize int64, now time.Time) []Entry {
+	sorted := append([]Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Saved.Before(sorted[j].Saved) })
+	var total int64
+	for _, e := range sorted {
+		total += e.Size
+	}
+	var expired []Entry
+	for _, e := range sorted {
+		tooOld := maxAge > 0 && now.Sub(e.Saved) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		expired = append(expired, e)
+		total -= e.Size
+	}
+	return expired
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	media := filepath.Join(root, "dvr", "Show")
+	os.MkdirAll(media, 0777)
+	original := filepath.Join(media, "ep.ts")
+	ioutil.WriteFile(original, []byte("first"), 0666)
+
+	s := &Store{Dir: filepath.Join(root, "backup")}
+	ctx := context.Background()
+	first, err := s.Save(ctx, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(s.Dir, original); first != want {
+		t.Errorf("Expected backup at %s, got %s", want, first)
+	}
+	ioutil.WriteFile(original, []byte("second"), 0666)
+	second, err := s.Save(ctx, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("Expected second backup not to replace the first")
+	}
+	// Saved within the same second as the second one.
+	ioutil.WriteFile(original, []byte("third"), 0666)
+	third, err := s.Save(ctx, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == second || third == first {
+		t.Fatal("Expected third backup not to replace the others")
+	}
+	os.Remove(third)
+
+	entries, _ := s.List()
+	if len(entries) != 2 || entries[0].Original != original || entries[1].Original != original {
+		t.Fatalf("Expected two backups of %s, got %+v", original, entries)
+	}
+
+	// Pruning to less than one backup keeps the one given.
+	removed, err := s.Prune(0, 1, second)
+	if err != nil || len(removed) != 1 || removed[0].Path != first {
+		t.Fatalf("Expected %s pruned, got %+v %v", first, removed, err)
+	}
+	ioutil.WriteFile(original, []byte("first"), 0666)
+	if first, err = s.Save(ctx, original); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := s.Find(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(ctx, e, false); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(original); string(buf) != "first" {
+		t.Errorf("Expected the earliest backup to be restored, got %q", buf)
+	}
+	e, _ = s.Find(second)
+	if err := s.Restore(ctx, e, false); err == nil {
+		t.Error("Expected restore over an existing file to fail")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	entries := []Entry{
+		{Path: "c", Size: 30, Saved: now.Add(-1 * day)},
+		{Path: "a", Size: 10, Saved: now.Add(-10 * day)},
+		{Path: "b", Size: 20, Saved: now.Add(-5 * day)},
+	}
+	check := func(maxAge time.Duration, maxSize int64, want ...string) {
+		t.Helper()
+		got := Expired(entries, maxAge, maxSize, now)
+		if len(got) != len(want) {
+			t.Fatalf("Expected %v expired, got %+v", want, got)
+		}
+		for i := range want {
+			if got[i].Path != want[i] {
+				t.Errorf("Expected %v expired, got %+v", want, got)
+			}
+		}
+	}
+	check(0, 0)
+	check(7*day, 0, "a")
+	check(0, 35, "a", "b")
+	check(2*day, 100, "a", "b")
+}
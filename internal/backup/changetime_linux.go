@@ -0,0 +1,18 @@
+//go:build linux
+
+package backup
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime is when the file was last renamed or changed, which for a
+// backup is when it was moved aside. Renames keep the recording's mtime.
+func changeTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Sec, st.Ctim.Nsec)
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package backup
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime falls back to the mtime where the change time isn't known.
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,131 @@
+// Package comskip reads the block scores out of comskip's log, so how sure
+// comskip was about each commercial can be weighed.
+package comskip
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/pkg/errors"
+)
+
+// DefaultThreshold is comskip's default global_threshold; blocks scoring
+// above it are commercials.
+const DefaultThreshold = 1.05
+
+// Block is one of the blocks comskip splits a recording into, with the score
+// it gave it. The higher the score, the surer comskip is that the block is a
+// commercial.
+type Block struct {
+	cutlist.Range
+	Score float64
+}
+
+var blockRow = regexp.MustCompile(`^\s*\d+:..(.*)$`)
+
+// ParseLog reads the last block table in a comskip log, as written with
+// verbose=1 or more. fps converts its frame numbers to seconds.
+func ParseLog(r io.Reader, fps float64) ([]Block, error) {
+	if fps <= 0 {
+		return nil, errors.New("frame rate is unknown")
+	}
+	var blocks, table []Block
+	var cols map[string]int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "#" {
+			cols = map[string]int{}
+			for i, name := range fields[1:] {
+				cols[name] = i
+			}
+			table = nil
+			continue
+		}
+		if cols == nil {
+			continue
+		}
+		m := blockRow.FindStringSubmatch(line)
+		if m == nil && table == nil && strings.Trim(line, "- ") == "" {
+			// A rule under the header.
+			continue
+		} else if m == nil {
+			if table != nil {
+				blocks = table
+			}
+			cols = nil
+			continue
+		}
+		b, ok := parseRow(strings.Fields(m[1]), cols, fps)
+		if !ok {
+			return nil, errors.Errorf("bad block row %q", line)
+		}
+		table = append(table, b)
+	}
+	if table != nil {
+		blocks = table
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if blocks == nil {
+		return nil, errors.New("no block table in log")
+	}
+	return blocks, nil
+}
+
+func parseRow(fields []string, cols map[string]int, fps float64) (Block, bool) {
+	get := func(name string) (float64, bool) {
+		i, ok := cols[name]
+		if !ok || i >= len(fields) {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(fields[i], 64)
+		return v, err == nil
+	}
+	fs, ok1 := get("fs")
+	fe, ok2 := get("fe")
+	score, ok3 := get("scr")
+	if !ok1 || !ok2 || !ok3 {
+		return Block{}, false
+	}
+	return Block{cutlist.Range{Begin: fs / fps, End: fe / fps}, score}, true
+}
+
+// Score returns the score of the blocks overlapping r, weighted by how much
+// of r each covers. It returns false if no block overlaps r.
+func Score(blocks []Block, r cutlist.Range) (float64, bool) {
+	var total, weight float64
+	for _, b := range blocks {
+		overlap := minFloat(b.End, r.End) - maxFloat(b.Begin, r.Begin)
+		if overlap <= 0 {
+			continue
+		}
+		total += b.Score * overlap
+		weight += overlap
+	}
+	if weight == 0 {
+		return 0, false
+	}
+	return total / weight, true
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,48 @@
+package comskip
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+)
+
+const sampleLog = `Block list before weighing
+-----------------------------------------------------------
+  #     sbf  bs  be     fs     fe        sc      len   scr cmb   ar                   cut bri  logo   vol  sil corr stdev        cc
+  0:-+    0   0   0      1    900     0.00    30.00  9.99   1  1.78                              0   0.00     0    0 0.00     0
+  1:--    0   0   0    900   9000     0.00   270.00  9.99   1  1.78                              0   0.00     0    0 0.00     0
+
+Block list after weighing
+-----------------------------------------------------------
+  #     sbf  bs  be     fs     fe        sc      len   scr cmb   ar                   cut bri  logo   vol  sil corr stdev        cc
+  0:++    0   0   0      1    900     0.00    30.00  3.50   1  1.78   L                          0   0.00     0    0 0.00     0
+  1:--    0   0   0    900   9000     0.00   270.00  0.40   1  1.78                              0   0.00     0    0 0.00     0
+  2:++    0   0   0   9000   9900     0.00    30.00  1.20   1  1.78   S                          0   0.00     0    0 0.00     0
+Confidence done
+`
+
+func TestParseLog(t *testing.T) {
+	blocks, err := ParseLog(strings.NewReader(sampleLog), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("Expected 3 blocks from the last table, got %v", blocks)
+	}
+	if b := blocks[2]; b.Begin != 300 || b.End != 330 || b.Score != 1.2 {
+		t.Errorf("Unexpected last block %+v", b)
+	}
+
+	score, ok := Score(blocks, cutlist.Range{Begin: 285, End: 330})
+	if want := (0.4*15 + 1.2*30) / 45; !ok || math.Abs(score-want) > 1e-9 {
+		t.Errorf("Expected score %v, got %v", want, score)
+	}
+	if _, ok := Score(blocks, cutlist.Range{Begin: 400, End: 500}); ok {
+		t.Error("Expected no score past the last block")
+	}
+	if _, err := ParseLog(strings.NewReader("nothing here\n"), 30); err == nil {
+		t.Error("Expected a log without blocks to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package cutlist
+
+// Accuracy measures how well found commercials agree with ones known to be
+// right. Times are in seconds, and add up over several recordings.
+type Accuracy struct {
+	// Found is the length of the commercials found and Truth of the real
+	// ones. Hit is how much of the two agree, and Judged how much of what
+	// was found falls where the truth is known either way.
+	Found, Truth, Hit, Judged float64
+	// BoundaryError adds up how far the boundaries of commercials found
+	// are from the real ones they overlap, over Boundaries boundaries.
+	BoundaryError float64
+	Boundaries    int
+}
+
+// Measure compares found commercials to the real ones. show is what's known
+// not to be commercials; the rest of the recording isn't judged.
+func Measure(found, commercials, show []Range) Accuracy {
+	a := Accuracy{
+		Found:  Duration(found),
+		Truth:  Duration(commercials),
+		Hit:    Duration(Covered([][]Range{found, commercials}, 2)),
+		Judged: Duration(Covered([][]Range{found, append(Normalize(commercials), Normalize(show)...)}, 2)),
+	}
+	for _, p := range Compare(found, commercials) {
+		if p.InA && p.InB {
+			a.BoundaryError += p.Difference
+			a.Boundaries += 2
+		}
+	}
+	return a
+}
+
+// Add adds up the measures of another recording.
+func (a *Accuracy) Add(b Accuracy) {
+	a.Found += b.Found
+	a.Truth += b.Truth
+	a.Hit += b.Hit
+	a.Judged += b.Judged
+	a.BoundaryError += b.BoundaryError
+	a.Boundaries += b.Boundaries
+}
+
+// Precision is the part of the judged commercials found that are real.
+func (a Accuracy) Precision() float64 {
+	if a.Judged == 0 {
+		return 0
+	}
+	return a.Hit / a.Judged
+}
+
+// Recall is the part of the real commercials that were found.
+func (a Accuracy) Recall() float64 {
+	if a.Truth == 0 {
+		return 0
+	}
+	return a.Hit / a.Truth
+}
+
+// MeanBoundaryError is how far off the average boundary is, in seconds.
+func (a Accuracy) MeanBoundaryError() float64 {
+	if a.Boundaries == 0 {
+		return 0
+	}
+	return a.BoundaryError / float64(a.Boundaries)
+}
+
+// Duration adds up the length of ranges, counting overlaps once.
+func Duration(ranges []Range) float64 {
+	var total float64
+	for _, r := range Normalize(ranges) {
+		total += r.Duration()
+	}
+	return total
+}
@@ -0,0 +1,36 @@
+package cutlist
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	commercials := []Range{{600, 780}, {1500, 1680}}
+	show := []Range{{0, 600}, {780, 1500}}
+	found := []Range{{590, 782}, {1200, 1230}}
+
+	a := Measure(found, commercials, show)
+	if a.Found != 222 || a.Truth != 360 || a.Hit != 180 || a.Judged != 222 {
+		t.Errorf("Unexpected measure %+v", a)
+	}
+	if want := 180.0 / 222; math.Abs(a.Precision()-want) > 1e-9 {
+		t.Errorf("Expected precision %v, got %v", want, a.Precision())
+	}
+	if a.Recall() != 0.5 {
+		t.Errorf("Expected recall 0.5, got %v", a.Recall())
+	}
+	if a.MeanBoundaryError() != 6 {
+		t.Errorf("Expected boundary error 6, got %v", a.MeanBoundaryError())
+	}
+
+	// Found time the viewer never watched isn't judged.
+	b := Measure([]Range{{1700, 1800}}, commercials, show)
+	if b.Judged != 0 || b.Precision() != 0 {
+		t.Errorf("Expected nothing judged, got %+v", b)
+	}
+	a.Add(b)
+	if a.Found != 322 || a.Judged != 222 {
+		t.Errorf("Unexpected sum %+v", a)
+	}
+}
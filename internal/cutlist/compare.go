@@ -0,0 +1,43 @@
+package cutlist
+
+// Pair lines up a range from each of two lists. Only one side is set when
+// the other list has nothing overlapping it.
+type Pair struct {
+	A, B       Range
+	InA, InB   bool
+	Difference float64
+}
+
+// Compare lines up the ranges of two lists that overlap, in order.
+// Difference is how far apart the boundaries of a matched pair are, added
+// together.
+func Compare(a, b []Range) []Pair {
+	a, b = Normalize(a), Normalize(b)
+	var pairs []Pair
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].End <= b[j].Begin):
+			pairs = append(pairs, Pair{A: a[i], InA: true})
+			i++
+		case i == len(a) || b[j].End <= a[i].Begin:
+			pairs = append(pairs, Pair{B: b[j], InB: true})
+			j++
+		default:
+			pairs = append(pairs, Pair{
+				A: a[i], B: b[j], InA: true, InB: true,
+				Difference: abs(a[i].Begin-b[j].Begin) + abs(a[i].End-b[j].End),
+			})
+			i++
+			j++
+		}
+	}
+	return pairs
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
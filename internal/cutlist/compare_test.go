@@ -0,0 +1,20 @@
+package cutlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	a := []Range{{0, 30}, {600, 780}, {1500, 1600}}
+	b := []Range{{598, 781}, {1200, 1300}}
+	want := []Pair{
+		{A: Range{0, 30}, InA: true},
+		{A: Range{600, 780}, B: Range{598, 781}, InA: true, InB: true, Difference: 3},
+		{B: Range{1200, 1300}, InB: true},
+		{A: Range{1500, 1600}, InA: true},
+	}
+	if got := Compare(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
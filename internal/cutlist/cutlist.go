@@ -0,0 +1,154 @@
+// Package cutlist works with lists of time ranges in a recording, such as
+// commercial breaks, and combines the lists found by different sources.
+package cutlist
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Range is a span of a recording in seconds.
+type Range struct {
+	Begin float64
+	End   float64
+}
+
+func (r Range) Duration() float64 {
+	return r.End - r.Begin
+}
+
+// Merge strategies for combining lists from several sources.
+const (
+	FirstNonEmpty = "first-non-empty"
+	Union         = "union"
+	Intersection  = "intersection"
+	Voting        = "voting"
+)
+
+// Merge combines lists with the named strategy. Voting keeps what a majority
+// of the lists agree on; a nil list counts as a source with no opinion and
+// doesn't vote.
+func Merge(strategy string, lists [][]Range) ([]Range, error) {
+	var voters [][]Range
+	for _, list := range lists {
+		if list != nil {
+			voters = append(voters, list)
+		}
+	}
+	switch strategy {
+	case "", FirstNonEmpty:
+		for _, list := range lists {
+			if len(list) != 0 {
+				return Normalize(list), nil
+			}
+		}
+		return nil, nil
+	case Union:
+		return Covered(voters, 1), nil
+	case Intersection:
+		return Covered(voters, len(voters)), nil
+	case Voting:
+		return Covered(voters, len(voters)/2+1), nil
+	default:
+		return nil, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+}
+
+// Covered returns the parts of the recording that at least min of the lists
+// cover.
+func Covered(lists [][]Range, min int) []Range {
+	if len(lists) == 0 || min < 1 {
+		return nil
+	}
+	type edge struct {
+		at    float64
+		delta int
+	}
+	var edges []edge
+	for _, list := range lists {
+		for _, r := range Normalize(list) {
+			edges = append(edges, edge{r.Begin, 1}, edge{r.End, -1})
+		}
+	}
+	// Ends sort before begins at the same time, so touching ranges from
+	// different lists don't count as overlapping.
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at != edges[j].at {
+			return edges[i].at < edges[j].at
+		}
+		return edges[i].delta < edges[j].delta
+	})
+
+	var out []Range
+	depth := 0
+	var begin float64
+	for _, e := range edges {
+		before := depth
+		depth += e.delta
+		if before < min && depth >= min {
+			begin = e.at
+		} else if before >= min && depth < min && e.at > begin {
+			out = append(out, Range{begin, e.at})
+		}
+	}
+	return Normalize(out)
+}
+
+// Normalize sorts ranges and joins the ones that overlap or touch, dropping
+// empty ones.
+func Normalize(ranges []Range) []Range {
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.End > r.Begin {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Begin < sorted[j].Begin })
+	var out []Range
+	for _, r := range sorted {
+		if n := len(out); n != 0 && r.Begin <= out[n-1].End {
+			if r.End > out[n-1].End {
+				out[n-1].End = r.End
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Invert returns the gaps between ranges within [0, duration].
+func Invert(ranges []Range, duration float64) []Range {
+	var out []Range
+	pos := 0.0
+	for _, r := range Normalize(ranges) {
+		if r.Begin > pos {
+			out = append(out, Range{pos, r.Begin})
+		}
+		if r.End > pos {
+			pos = r.End
+		}
+	}
+	if duration > pos {
+		out = append(out, Range{pos, duration})
+	}
+	return out
+}
+
+// Retime maps ranges onto the timeline left after cutting a recording down
+// to the kept ranges, dropping the parts that were cut.
+func Retime(ranges, kept []Range) []Range {
+	var out []Range
+	offset := 0.0
+	for _, k := range Normalize(kept) {
+		for _, r := range Normalize(ranges) {
+			begin, end := math.Max(r.Begin, k.Begin), math.Min(r.End, k.End)
+			if end > begin {
+				out = append(out, Range{begin - k.Begin + offset, end - k.Begin + offset})
+			}
+		}
+		offset += k.Duration()
+	}
+	return Normalize(out)
+}
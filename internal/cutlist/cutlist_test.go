@@ -0,0 +1,55 @@
+package cutlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	comskip := []Range{{0, 30}, {600, 780}, {1500, 1620}}
+	chapters := []Range{{610, 790}, {1500, 1620}}
+	watchlog := []Range{{0, 25}, {620, 780}}
+
+	cases := []struct {
+		strategy string
+		lists    [][]Range
+		want     []Range
+	}{
+		{FirstNonEmpty, [][]Range{nil, {}, chapters, comskip}, chapters},
+		{Union, [][]Range{comskip, chapters}, []Range{{0, 30}, {600, 790}, {1500, 1620}}},
+		{Intersection, [][]Range{comskip, chapters}, []Range{{610, 780}, {1500, 1620}}},
+		{Intersection, [][]Range{comskip, nil}, comskip},
+		{Voting, [][]Range{comskip, chapters, watchlog}, []Range{{0, 25}, {610, 780}, {1500, 1620}}},
+	}
+	for _, c := range cases {
+		got, err := Merge(c.strategy, c.lists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.strategy, c.want, got)
+		}
+	}
+	if _, err := Merge("bogus", nil); err == nil {
+		t.Error("Expected unknown strategy to fail")
+	}
+}
+
+func TestNormalizeInvert(t *testing.T) {
+	got := Normalize([]Range{{50, 60}, {10, 20}, {15, 30}, {30, 35}, {40, 40}})
+	want := []Range{{10, 35}, {50, 60}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Normalize: expected %v, got %v", want, got)
+	}
+	got = Invert(want, 100)
+	want = []Range{{0, 10}, {35, 50}, {60, 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invert: expected %v, got %v", want, got)
+	}
+
+	got = Retime([]Range{{100, 130}, {290, 310}}, []Range{{0, 300}, {400, 1000}})
+	want = []Range{{100, 130}, {290, 300}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Retime: expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,207 @@
+package cutlist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/timescale"
+)
+
+// Chapter is a named part of a recording, either program or commercial.
+type Chapter struct {
+	Begin        float64
+	End          float64
+	Name         string
+	IsCommercial bool
+}
+
+// Export formats, besides EDL.
+const (
+	XMLChapters = "xml"
+	Jellyfin    = "jellyfin"
+	CSV         = "csv"
+)
+
+// exportMarker sets videoproc's own EDL exports apart from the EDLs other
+// tools leave, so they aren't read back as a sidecar.
+const exportMarker = ".videoproc"
+
+var exportExts = map[string]string{
+	EDL:         exportMarker + ".edl",
+	XMLChapters: ".chapters.xml",
+	Jellyfin:    ".segments.json",
+	CSV:         ".csv",
+}
+
+// ExportExt returns the suffix for a sidecar in the given export format.
+func ExportExt(format string) (string, error) {
+	ext, ok := exportExts[format]
+	if !ok {
+		return "", fmt.Errorf("unknown export format %q", format)
+	}
+	return ext, nil
+}
+
+// IsExport reports whether path is named like one of videoproc's exports.
+func IsExport(path string) bool {
+	return strings.HasSuffix(path, exportExts[EDL])
+}
+
+// Export writes chapters in the given format.
+func Export(w io.Writer, format string, chapters []Chapter) error {
+	switch format {
+	case EDL:
+		return WriteEDL(w, chapters)
+	case XMLChapters:
+		return WriteXMLChapters(w, chapters)
+	case Jellyfin:
+		return WriteJellyfin(w, chapters)
+	case CSV:
+		return WriteCSV(w, chapters)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// WriteEDL writes the commercials as an EDL with the skip action, which both
+// Kodi and MPlayer honor.
+func WriteEDL(w io.Writer, chapters []Chapter) error {
+	for _, c := range chapters {
+		if !c.IsCommercial {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%.3f\t%.3f\t%d\n", c.Begin, c.End, edlCut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mkvChapters struct {
+	XMLName  xml.Name     `xml:"Chapters"`
+	Editions []mkvEdition `xml:"EditionEntry"`
+}
+
+type mkvEdition struct {
+	Default int           `xml:"EditionFlagDefault,omitempty"`
+	Ordered int           `xml:"EditionFlagOrdered,omitempty"`
+	Display *mkvEdDisplay `xml:"EditionDisplay"`
+	Atoms   []mkvAtom     `xml:"ChapterAtom"`
+}
+
+type mkvEdDisplay struct {
+	String string `xml:"EditionString"`
+}
+
+type mkvAtom struct {
+	Start   string     `xml:"ChapterTimeStart"`
+	End     string     `xml:"ChapterTimeEnd"`
+	Hidden  int        `xml:"ChapterFlagHidden,omitempty"`
+	Enabled *int       `xml:"ChapterFlagEnabled"`
+	Display mkvDisplay `xml:"ChapterDisplay"`
+}
+
+type mkvDisplay struct {
+	String   string `xml:"ChapterString"`
+	Language string `xml:"ChapterLanguage"`
+}
+
+func mkvAtoms(chapters []Chapter) []mkvAtom {
+	var atoms []mkvAtom
+	for _, c := range chapters {
+		atoms = append(atoms, mkvAtom{
+			Start:   timescale.TimestampMKV(timescale.Offset(c.Begin)),
+			End:     timescale.TimestampMKV(timescale.Offset(c.End)),
+			Display: mkvDisplay{c.Name, "eng"},
+		})
+	}
+	return atoms
+}
+
+// WriteXMLChapters writes a Matroska XML chapters file, as read by
+// mkvmerge and mkvpropedit.
+func WriteXMLChapters(w io.Writer, chapters []Chapter) error {
+	return writeMKVChapters(w, mkvChapters{Editions: []mkvEdition{{Atoms: mkvAtoms(chapters)}}})
+}
+
+// WriteEditions writes Matroska XML chapters with two editions: a default
+// ordered "No commercials" edition, in which the commercials are hidden and
+// disabled so players skip them, and a "Full recording" edition.
+func WriteEditions(w io.Writer, chapters []Chapter) error {
+	disabled := 0
+	skipping := mkvAtoms(chapters)
+	for i, c := range chapters {
+		if c.IsCommercial {
+			skipping[i].Hidden = 1
+			skipping[i].Enabled = &disabled
+		}
+	}
+	return writeMKVChapters(w, mkvChapters{Editions: []mkvEdition{
+		{Default: 1, Ordered: 1, Display: &mkvEdDisplay{"No commercials"}, Atoms: skipping},
+		{Display: &mkvEdDisplay{"Full recording"}, Atoms: mkvAtoms(chapters)},
+	}})
+}
+
+func writeMKVChapters(w io.Writer, doc mkvChapters) error {
+	buf, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
+
+// jellyfinTicks is the resolution of Jellyfin's media segment times.
+const jellyfinTicks = 10000000
+
+type jellyfinSegment struct {
+	Type       string
+	StartTicks int64
+	EndTicks   int64
+}
+
+// WriteJellyfin writes the commercials as a list of Jellyfin media segments.
+func WriteJellyfin(w io.Writer, chapters []Chapter) error {
+	segments := []jellyfinSegment{}
+	for _, c := range chapters {
+		if c.IsCommercial {
+			segments = append(segments, jellyfinSegment{
+				Type:       "Commercial",
+				StartTicks: int64(math.Round(c.Begin * jellyfinTicks)),
+				EndTicks:   int64(math.Round(c.End * jellyfinTicks)),
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(segments)
+}
+
+// WriteCSV writes every chapter as a row of start, end, type and name.
+func WriteCSV(w io.Writer, chapters []Chapter) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"start", "end", "type", "name"})
+	for _, c := range chapters {
+		kind := "program"
+		if c.IsCommercial {
+			kind = "commercial"
+		}
+		cw.Write([]string{
+			strconv.FormatFloat(c.Begin, 'f', 3, 64),
+			strconv.FormatFloat(c.End, 'f', 3, 64),
+			kind,
+			c.Name,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,65 @@
+package cutlist
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	chapters := []Chapter{
+		{0, 600, "Segment 1", false},
+		{600, 780.5, "Commercial 1", true},
+		{780.5, 1800, "Segment 2", false},
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, EDL, chapters); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEDL(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Range{{600, 780.5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EDL: expected %v, got %v", want, got)
+	}
+
+	buf.Reset()
+	Export(&buf, Jellyfin, chapters)
+	if want := `"StartTicks": 6000000000`; !strings.Contains(buf.String(), want) {
+		t.Errorf("Jellyfin: expected %s in %s", want, buf.String())
+	}
+
+	buf.Reset()
+	Export(&buf, CSV, chapters)
+	if want := "600.000,780.500,commercial,Commercial 1\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("CSV: expected %q in %s", want, buf.String())
+	}
+
+	buf.Reset()
+	Export(&buf, XMLChapters, chapters)
+	if want := "<ChapterTimeStart>00:13:00.500</ChapterTimeStart>"; !strings.Contains(buf.String(), want) {
+		t.Errorf("XML: expected %s in %s", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteEditions(&buf, chapters); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "<EditionEntry>"); n != 2 {
+		t.Errorf("Editions: expected 2 editions, got %d in %s", n, out)
+	}
+	if n := strings.Count(out, "<ChapterFlagEnabled>0</ChapterFlagEnabled>"); n != 1 {
+		t.Errorf("Editions: expected the commercial disabled once, got %d in %s", n, out)
+	}
+	if !strings.Contains(out, "<EditionFlagOrdered>1</EditionFlagOrdered>") {
+		t.Errorf("Editions: expected an ordered edition in %s", out)
+	}
+
+	if _, err := ExportExt("bogus"); err == nil {
+		t.Error("Expected unknown format to fail")
+	}
+}
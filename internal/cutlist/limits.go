@@ -0,0 +1,64 @@
+package cutlist
+
+import "fmt"
+
+// Limits are sanity limits on a recording's commercials. Durations are in
+// seconds, and zero means no limit.
+type Limits struct {
+	MinCommercial float64
+	MaxCommercial float64
+	MinSegment    float64
+	// MaxFraction is the most of the recording that may be commercials.
+	MaxFraction float64
+	MaxCount    int
+}
+
+// Tidy joins commercials shorter than MinCommercial to a neighbor less than
+// MinSegment away, and drops the ones that are still too short.
+func (l Limits) Tidy(commercials []Range) []Range {
+	commercials = Normalize(commercials)
+	if l.MinCommercial <= 0 {
+		return commercials
+	}
+	var joined []Range
+	for _, c := range commercials {
+		if n := len(joined); n != 0 && c.Begin-joined[n-1].End < l.MinSegment &&
+			(c.Duration() < l.MinCommercial || joined[n-1].Duration() < l.MinCommercial) {
+			joined[n-1].End = c.End
+			continue
+		}
+		joined = append(joined, c)
+	}
+	var out []Range
+	for _, c := range joined {
+		if c.Duration() >= l.MinCommercial {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Check describes each limit the commercials break in a recording of the
+// given duration.
+func (l Limits) Check(commercials []Range, duration float64) []string {
+	var problems []string
+	if l.MaxCount > 0 && len(commercials) > l.MaxCount {
+		problems = append(problems, fmt.Sprintf("%d commercials, over the limit of %d", len(commercials), l.MaxCount))
+	}
+	var total float64
+	for i, c := range commercials {
+		total += c.Duration()
+		if l.MaxCommercial > 0 && c.Duration() > l.MaxCommercial {
+			problems = append(problems, fmt.Sprintf("commercial %d is %.0fs, over the limit of %.0fs", i+1, c.Duration(), l.MaxCommercial))
+		}
+		if i != 0 && l.MinSegment > 0 {
+			if gap := c.Begin - commercials[i-1].End; gap < l.MinSegment {
+				problems = append(problems, fmt.Sprintf("the show between commercials %d and %d is %.0fs, under the limit of %.0fs", i, i+1, gap, l.MinSegment))
+			}
+		}
+	}
+	if l.MaxFraction > 0 && duration > 0 && total/duration > l.MaxFraction {
+		problems = append(problems, fmt.Sprintf("commercials are %.0f%% of the recording, over the limit of %.0f%%", total/duration*100, l.MaxFraction*100))
+	}
+	return problems
+}
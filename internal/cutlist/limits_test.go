@@ -0,0 +1,24 @@
+package cutlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	l := Limits{MinCommercial: 10, MaxCommercial: 300, MinSegment: 60, MaxFraction: 0.4, MaxCount: 3}
+
+	got := l.Tidy([]Range{{0, 5}, {600, 780}, {800, 805}, {1200, 1204}})
+	want := []Range{{600, 805}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tidy: expected %v, got %v", want, got)
+	}
+
+	if problems := l.Check(want, 1800); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	problems := l.Check([]Range{{0, 400}, {420, 600}, {900, 950}, {1000, 1100}}, 1800)
+	if len(problems) != 5 {
+		t.Errorf("Expected count, length, two segment and fraction problems, got %v", problems)
+	}
+}
@@ -0,0 +1,79 @@
+package cutlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/timescale"
+)
+
+// ParseCuts reads a cut list written by hand, such as
+// "0:00-0:45,12:30-15:10,58:00-end". Times are as timescale.Parse takes
+// them, and an end of "end" runs to the end of the recording, as +Inf.
+func ParseCuts(s string) ([]Range, error) {
+	var ranges []Range
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.Split(part, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("bad cut %q, expected begin-end", part)
+		}
+		begin, err := timescale.Parse(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		r := Range{Begin: begin.Float(), End: math.Inf(1)}
+		if end := strings.TrimSpace(bounds[1]); end != "end" {
+			e, err := timescale.Parse(end)
+			if err != nil {
+				return nil, err
+			}
+			r.End = e.Float()
+		}
+		if r.End <= r.Begin {
+			return nil, fmt.Errorf("cut %q ends before it begins", part)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no cuts in %q", s)
+	}
+	return Normalize(ranges), nil
+}
+
+// ReadCuts reads a file of cuts as ParseCuts takes them, any number to a
+// line. Blank lines and ones starting with # are skipped.
+func ReadCuts(r io.Reader) ([]Range, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseCuts(strings.Join(lines, ","))
+}
+
+// Trim returns the part kept when cuts only take off the head or tail of a
+// recording, which can be trimmed without cutting anything out of the middle.
+func Trim(cuts []Range, duration float64) (Range, bool) {
+	kept := Invert(cuts, duration)
+	if len(kept) != 1 {
+		return Range{}, false
+	}
+	kept[0].End = math.Min(kept[0].End, duration)
+	if kept[0] == (Range{0, duration}) {
+		return Range{}, false
+	}
+	return kept[0], true
+}
@@ -0,0 +1,51 @@
+package cutlist
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCuts(t *testing.T) {
+	got, err := ParseCuts("12:30-15:10, 0:00-0:45,58:00-end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Range{{0, 45}, {750, 910}, {3480, math.Inf(1)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	got, err = ReadCuts(strings.NewReader("# pre-roll\n0-45\n\n12:30-15:10,58:00-end\n"))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadCuts: expected %v, got %v (%v)", want, got, err)
+	}
+
+	for _, bad := range []string{"", "0:45", "1:00-0:30", "a-b", "1-2-3"} {
+		if _, err := ParseCuts(bad); err == nil {
+			t.Errorf("Expected %q to fail", bad)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	cases := []struct {
+		cuts []Range
+		want Range
+		ok   bool
+	}{
+		{[]Range{{0, 45}, {3480, math.Inf(1)}}, Range{45, 3480}, true},
+		{[]Range{{0, 45}}, Range{45, 3600}, true},
+		{[]Range{{3480, 3600}}, Range{0, 3480}, true},
+		{[]Range{{0, 45}, {750, 910}, {3480, math.Inf(1)}}, Range{}, false},
+		{[]Range{{750, 910}}, Range{}, false},
+		{[]Range{{4000, 4100}}, Range{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Trim(c.cuts, 3600)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%v: expected %v %v, got %v %v", c.cuts, c.want, c.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cutlist
+
+import "math"
+
+// Rounding modes for moving cut points to whole seconds.
+const (
+	// NoRounding leaves cut points alone.
+	NoRounding = ""
+	// RoundNearest moves each cut point to the nearest second.
+	RoundNearest = "nearest"
+	// RoundKeepMore rounds commercials inwards, so the show only grows.
+	RoundKeepMore = "keep-more"
+	// RoundKeepLess rounds commercials outwards, so the show only shrinks.
+	RoundKeepLess = "keep-less"
+)
+
+// ValidRounding reports whether mode is one of the rounding modes.
+func ValidRounding(mode string) bool {
+	switch mode {
+	case NoRounding, RoundNearest, RoundKeepMore, RoundKeepLess:
+		return true
+	}
+	return false
+}
+
+// Padding is how much of the show to keep around each commercial, in
+// seconds. Begin is kept before each segment of the show begins, and End
+// after each one ends; negative padding cuts into the show instead.
+type Padding struct {
+	Begin    float64
+	End      float64
+	Rounding string
+}
+
+// Apply pads and rounds commercials in a recording of duration seconds. The
+// recording's own start and end aren't moved. Commercials that padding
+// empties are dropped.
+func (p Padding) Apply(commercials []Range, duration float64) []Range {
+	var out []Range
+	for _, c := range commercials {
+		if c.Begin > 0.5 {
+			c.Begin = p.round(c.Begin+p.End, math.Ceil, math.Floor)
+		}
+		if c.End < duration-0.5 {
+			c.End = p.round(c.End-p.Begin, math.Floor, math.Ceil)
+		}
+		c.Begin = math.Max(c.Begin, 0)
+		c.End = math.Min(c.End, duration)
+		if c.End > c.Begin {
+			out = append(out, c)
+		}
+	}
+	return Normalize(out)
+}
+
+func (p Padding) round(v float64, keepMore, keepLess func(float64) float64) float64 {
+	switch p.Rounding {
+	case RoundNearest:
+		return math.Round(v)
+	case RoundKeepMore:
+		return keepMore(v)
+	case RoundKeepLess:
+		return keepLess(v)
+	}
+	return v
+}
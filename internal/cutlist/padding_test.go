@@ -0,0 +1,25 @@
+package cutlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPadding(t *testing.T) {
+	commercials := []Range{{0, 30.4}, {600.2, 780.6}, {1000, 1001}, {1750.5, 1800}}
+	p := Padding{Begin: 2, End: 1, Rounding: RoundKeepMore}
+	got := p.Apply(commercials, 1800)
+	want := []Range{{0, 28}, {602, 778}, {1752, 1800}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	p = Padding{Begin: -1, Rounding: RoundKeepLess}
+	got = p.Apply([]Range{{600.2, 780.6}}, 1800)
+	if want := []Range{{600, 782}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if !ValidRounding(RoundNearest) || ValidRounding("up") {
+		t.Error("ValidRounding disagrees with the rounding modes")
+	}
+}
@@ -0,0 +1,307 @@
+package cutlist
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/timescale"
+	"github.com/pkg/errors"
+)
+
+// Sidecar formats, which are cut lists left next to a recording by other
+// tools.
+const (
+	EDL       = "edl"
+	Comskip   = "comskip"
+	MythTV    = "mythtv"
+	VideoReDo = "videoredo"
+)
+
+var sidecarExts = []struct {
+	ext    string
+	format string
+}{
+	{".edl", EDL},
+	{".txt", Comskip},
+	{".cutlist", MythTV},
+	{".Vprj", VideoReDo},
+	{".vprj", VideoReDo},
+}
+
+// FindSidecar looks for a cut list next to a recording, given its path
+// without the extension. It returns the path and format of the first one
+// found. videoproc's own exports are skipped.
+func FindSidecar(base string) (path, format string) {
+	for _, s := range sidecarExts {
+		if IsExport(base + s.ext) {
+			continue
+		}
+		if _, err := os.Stat(base + s.ext); err == nil {
+			return base + s.ext, s.format
+		}
+	}
+	return "", ""
+}
+
+// FormatFor returns the sidecar format for a file's extension, or "".
+func FormatFor(path string) string {
+	ext := filepath.Ext(path)
+	for _, s := range sidecarExts {
+		if s.ext == ext {
+			return s.format
+		}
+	}
+	return ""
+}
+
+// ReadFile reads a sidecar file in the given format. fps converts frame
+// numbers to seconds, for the formats that use them.
+func ReadFile(path, format string, fps float64) ([]Range, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranges, err := Read(f, format, fps)
+	return ranges, errors.Wrapf(err, "read %s", path)
+}
+
+// Read parses a cut list in the given format.
+func Read(r io.Reader, format string, fps float64) ([]Range, error) {
+	switch format {
+	case EDL:
+		return ReadEDL(r, fps)
+	case Comskip:
+		return ReadComskip(r, fps)
+	case MythTV:
+		return ReadMythTV(r, fps)
+	case VideoReDo:
+		return ReadVideoReDo(r, fps)
+	default:
+		return nil, fmt.Errorf("unknown cut list format %q", format)
+	}
+}
+
+// ErrNotCutList is returned when a file with a sidecar's extension turns out
+// to be something else, such as a .txt that comskip didn't write.
+var ErrNotCutList = errors.New("not a cut list")
+
+// Kodi EDL actions that remove content.
+const (
+	edlCut        = 0
+	edlCommercial = 3
+)
+
+// ReadEDL parses a Kodi EDL file. Each line holds a start, an end and an
+// optional action; times are seconds, HH:MM:SS.sss, or frame numbers
+// prefixed with #. Only cuts and commercial breaks are returned, not mutes
+// or scene markers.
+func ReadEDL(r io.Reader, fps float64) ([]Range, error) {
+	var out []Range
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected start, end and action", line)
+		}
+		action := edlCut
+		if len(fields) == 3 {
+			var err error
+			if action, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: bad action %q", line, fields[2])
+			}
+		}
+		begin, err := parseEDLTime(fields[0], fps)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		end, err := parseEDLTime(fields[1], fps)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if action == edlCut || action == edlCommercial {
+			out = append(out, Range{begin, end})
+		}
+	}
+	return out, scanner.Err()
+}
+
+func parseEDLTime(s string, fps float64) (float64, error) {
+	switch {
+	case strings.HasPrefix(s, "#"):
+		frame, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad frame %q", s)
+		}
+		return frameTime(frame, fps)
+	case strings.Count(s, ":") == 2:
+		offset, err := timescale.ParseMKV(s)
+		if err != nil {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		return offset.Float(), nil
+	default:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		return v, nil
+	}
+}
+
+var comskipHeader = regexp.MustCompile(`^FILE PROCESSING COMPLETE\s+\d+\s+FRAMES AT\s+(\d+)`)
+
+// ReadComskip parses comskip's .txt output, which lists the commercials as
+// frame numbers. The frame rate in its header, in hundredths, wins over fps.
+func ReadComskip(r io.Reader, fps float64) ([]Range, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, ErrNotCutList
+	}
+	m := comskipHeader.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+	if m == nil {
+		return nil, ErrNotCutList
+	}
+	if rate, _ := strconv.Atoi(m[1]); rate > 0 {
+		fps = float64(rate) / 100
+	}
+
+	var out []Range
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "---") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected start and end frames", line)
+		}
+		r, err := frameRange(fields[0], fields[1], fps)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		out = append(out, r)
+	}
+	return out, scanner.Err()
+}
+
+// ReadMythTV parses a MythTV cut list or commercial skip list as printed by
+// mythutil, such as "Cutlist: 0-1204,20313-25012".
+func ReadMythTV(r io.Reader, fps float64) ([]Range, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var out []Range
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.LastIndex(line, ":"); i >= 0 {
+			line = line[i+1:]
+		}
+		for _, pair := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			frames := strings.SplitN(pair, "-", 2)
+			if len(frames) != 2 {
+				return nil, fmt.Errorf("bad cut %q", pair)
+			}
+			r, err := frameRange(frames[0], frames[1], fps)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// vprjTicks is the resolution of VideoReDo's cut times.
+const vprjTicks = 10000000
+
+type vprjProject struct {
+	Cuts []struct {
+		Start     string `xml:"CutStart,attr"`
+		End       string `xml:"CutEnd,attr"`
+		TimeStart *int64 `xml:"CutTimeStart"`
+		TimeEnd   *int64 `xml:"CutTimeEnd"`
+	} `xml:"CutList>Cut"`
+}
+
+// ReadVideoReDo parses the cuts in a VideoReDo project (.Vprj) file. Newer
+// projects give cut times in 100ns ticks; older ones only have HH:MM:SS;FF
+// timecodes, which need fps.
+func ReadVideoReDo(r io.Reader, fps float64) ([]Range, error) {
+	var project vprjProject
+	if err := xml.NewDecoder(r).Decode(&project); err != nil {
+		return nil, errors.Wrap(err, "parse project")
+	}
+	var out []Range
+	for _, cut := range project.Cuts {
+		if cut.TimeStart != nil && cut.TimeEnd != nil {
+			out = append(out, Range{float64(*cut.TimeStart) / vprjTicks, float64(*cut.TimeEnd) / vprjTicks})
+			continue
+		}
+		begin, err := parseTimecode(cut.Start, fps)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimecode(cut.End, fps)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Range{begin, end})
+	}
+	return out, nil
+}
+
+// parseTimecode parses HH:MM:SS;FF or HH:MM:SS:FF.
+func parseTimecode(s string, fps float64) (float64, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == ';' })
+	if len(parts) != 4 {
+		return 0, fmt.Errorf("bad timecode %q", s)
+	}
+	var n [4]int64
+	for i, p := range parts {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad timecode %q", s)
+		}
+		n[i] = v
+	}
+	frames, err := frameTime(n[3], fps)
+	if err != nil {
+		return 0, err
+	}
+	return float64(n[0]*3600+n[1]*60+n[2]) + frames, nil
+}
+
+func frameRange(begin, end string, fps float64) (Range, error) {
+	b, err := strconv.ParseInt(begin, 10, 64)
+	if err != nil {
+		return Range{}, fmt.Errorf("bad frame %q", begin)
+	}
+	e, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return Range{}, fmt.Errorf("bad frame %q", end)
+	}
+	bt, err := frameTime(b, fps)
+	if err != nil {
+		return Range{}, err
+	}
+	et, _ := frameTime(e, fps)
+	return Range{bt, et}, nil
+}
+
+func frameTime(frame int64, fps float64) (float64, error) {
+	if fps <= 0 {
+		return 0, errors.New("frame numbers given but the frame rate is unknown")
+	}
+	return float64(frame) / fps, nil
+}
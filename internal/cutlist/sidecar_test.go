@@ -0,0 +1,70 @@
+package cutlist
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSidecars(t *testing.T) {
+	cases := []struct {
+		format string
+		input  string
+		want   []Range
+	}{
+		{EDL, "0.00\t29.93\t0\n600.5 780 3\n900 901 1\n\n1500 1620\n", []Range{{0, 29.93}, {600.5, 780}, {1500, 1620}}},
+		{EDL, "#0 #900 3\n00:10:00.000 00:13:00.500 0\n", []Range{{0, 30}, {600, 780.5}}},
+		{Comskip, "FILE PROCESSING COMPLETE  53954 FRAMES AT  2500\n-------------------\n1\t750\n15000\t19500\n", []Range{{0.04, 30}, {600, 780}}},
+		{MythTV, "Cutlist: 0-900,18000-23400\n", []Range{{0, 30}, {600, 780}}},
+		{VideoReDo, `<VideoReDoProject Version="5"><CutList>
+<Cut Sequence="1" CutStart="00:00:00;00" CutEnd="00:00:30;00"><CutTimeStart>0</CutTimeStart><CutTimeEnd>300000000</CutTimeEnd></Cut>
+<Cut Sequence="2" CutStart="00:10:00;00" CutEnd="00:13:00;15"></Cut>
+</CutList></VideoReDoProject>`, []Range{{0, 30}, {600, 780.5}}},
+	}
+	for _, c := range cases {
+		got, err := Read(strings.NewReader(c.input), c.format, 30)
+		if err != nil {
+			t.Fatalf("%s: %s", c.format, err)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: expected %v, got %v", c.format, c.want, got)
+		}
+		for i := range got {
+			if math.Abs(got[i].Begin-c.want[i].Begin) > 0.001 || math.Abs(got[i].End-c.want[i].End) > 0.001 {
+				t.Errorf("%s: expected %v, got %v", c.format, c.want, got)
+				break
+			}
+		}
+	}
+
+	if _, err := ReadComskip(strings.NewReader("just some notes\n"), 30); err != ErrNotCutList {
+		t.Errorf("Expected ErrNotCutList, got %v", err)
+	}
+	if _, err := ReadMythTV(strings.NewReader("0-900"), 0); err == nil {
+		t.Error("Expected frames without a frame rate to fail")
+	}
+}
+
+func TestFindSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "show")
+	ext, _ := ExportExt(EDL)
+	ioutil.WriteFile(base+ext, []byte("600 780 0\n"), 0666)
+	if path, _ := FindSidecar(base); path != "" {
+		t.Errorf("Expected an export not to be taken as a sidecar, got %s", path)
+	}
+	if path, _ := FindSidecar(base + ".videoproc"); path != "" {
+		t.Errorf("Expected an export not to be taken as a sidecar, got %s", path)
+	}
+	ioutil.WriteFile(base+".edl", []byte("600 780 0\n"), 0666)
+	if path, format := FindSidecar(base); path != base+".edl" || format != EDL {
+		t.Errorf("Expected %s.edl, got %s %s", base, path, format)
+	}
+}
@@ -0,0 +1,60 @@
+// Package ffcmd builds ffmpeg command lines.
+package ffcmd
+
+import "strconv"
+
+// Command is an ffmpeg command line. ffmpeg applies options to the file that
+// follows them, so inputs and output options are collected apart and only
+// put in order by Args.
+type Command struct {
+	input  []string
+	extra  []string
+	output []string
+	nextra int
+}
+
+// SetInput sets the main input, which is always input 0, with any options
+// that go before it.
+func (c *Command) SetInput(path string, opts ...string) {
+	c.input = append(append([]string{}, opts...), "-i", path)
+}
+
+// AddInput adds a further input, such as a chapter metadata file, and
+// returns its index for -map_metadata and -map_chapters.
+func (c *Command) AddInput(path string, opts ...string) string {
+	c.extra = append(append(c.extra, opts...), "-i", path)
+	c.nextra++
+	return strconv.Itoa(c.nextra)
+}
+
+// AddOutput adds output options.
+func (c *Command) AddOutput(opts ...string) {
+	c.output = append(c.output, opts...)
+}
+
+// HasOutput reports whether any output options were added.
+func (c *Command) HasOutput() bool {
+	return len(c.output) != 0 || c.nextra != 0
+}
+
+// Filter appends filter to the chain given by flag, -vf or -af, starting
+// the chain if there isn't one yet.
+func (c *Command) Filter(flag, filter string) {
+	for i := 0; i+1 < len(c.output); i++ {
+		if c.output[i] == flag {
+			c.output[i+1] += "," + filter
+			return
+		}
+	}
+	c.AddOutput(flag, filter)
+}
+
+// Args returns the command line, after the global options, writing to
+// outFile.
+func (c *Command) Args(global []string, outFile string) []string {
+	args := append([]string{}, global...)
+	args = append(args, c.input...)
+	args = append(args, c.extra...)
+	args = append(args, c.output...)
+	return append(args, outFile)
+}
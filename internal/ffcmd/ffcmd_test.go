@@ -0,0 +1,37 @@
+package ffcmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	var c Command
+	// Cut out with filters, then chapters marked from metadata, the order
+	// processVideo adds them in.
+	c.AddOutput("-vf", "select='between(t,0,10)'", "-af", "aselect='between(t,0,10)'")
+	meta := c.AddInput("meta.txt")
+	c.SetInput("in.ts")
+	c.AddOutput("-map_metadata", meta, "-map_chapters", meta)
+	c.Filter("-vf", "yadif")
+	c.Filter("-af", "volume=2")
+	got := c.Args([]string{"-nostdin"}, "out.mkv")
+	want := []string{
+		"-nostdin", "-i", "in.ts", "-i", "meta.txt",
+		"-vf", "select='between(t,0,10)',yadif", "-af", "aselect='between(t,0,10)',volume=2",
+		"-map_metadata", "1", "-map_chapters", "1",
+		"out.mkv",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	var concat Command
+	concat.SetInput("parts.txt", "-f", "concat", "-safe", "0")
+	concat.Filter("-vf", "crop=1440:1080")
+	got = concat.Args(nil, "out.mkv")
+	want = []string{"-f", "concat", "-safe", "0", "-i", "parts.txt", "-vf", "crop=1440:1080", "out.mkv"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package fileio
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// FICLONE from linux/fs.h, which x/sys doesn't have yet.
+const ficlone = 0x40049409
+
+// copyChunk bounds each copy_file_range or sendfile call, so cancellation is
+// noticed between chunks.
+const copyChunk = 64 << 20
+
+// The copy methods, swapped out in tests to force each fallback.
+var (
+	reflink = func(dst, src *os.File) error {
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dst.Fd(), ficlone, src.Fd()); errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	copyFileRange = func(dst, src *os.File) (int, error) {
+		return unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, copyChunk, 0)
+	}
+	sendfile = func(dst, src *os.File) (int, error) {
+		return unix.Sendfile(int(dst.Fd()), int(src.Fd()), nil, copyChunk)
+	}
+)
+
+// copyData tries, in order, a reflink (instant on btrfs and xfs), an
+// in-kernel copy_file_range (server side on NFS and CIFS), sendfile and then
+// a plain buffered copy. Each is skipped if the filesystems don't support it.
+func copyData(ctx context.Context, dst, src *os.File) (Method, error) {
+	if reflink(dst, src) == nil {
+		return MethodReflink, nil
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	copied, err := kernelCopy(ctx, info.Size(), func() (int, error) { return copyFileRange(dst, src) })
+	if copied || err != nil {
+		return MethodCopyFileRange, err
+	}
+
+	copied, err = kernelCopy(ctx, info.Size(), func() (int, error) { return sendfile(dst, src) })
+	if copied || err != nil {
+		return MethodSendfile, err
+	}
+
+	return MethodBuffered, copyBuffered(ctx, dst, src)
+}
+
+// kernelCopy calls copyChunk until it reports the end of a file of size
+// bytes. It returns false without an error if the first call shows the
+// method isn't supported for these files, so the next one can be tried. Some
+// filesystems report that by copying nothing at all.
+func kernelCopy(ctx context.Context, size int64, copyChunk func() (int, error)) (bool, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+		n, err := copyChunk()
+		if err != nil {
+			if total == 0 && unsupported(err) {
+				return false, nil
+			}
+			return true, err
+		}
+		if n == 0 {
+			switch {
+			case total == 0 && size > 0:
+				return false, nil
+			case total < size:
+				return true, io.ErrUnexpectedEOF
+			}
+			return true, nil
+		}
+		total += int64(n)
+	}
+}
+
+func unsupported(err error) bool {
+	switch err {
+	case unix.ENOSYS, unix.EXDEV, unix.EINVAL, unix.EOPNOTSUPP, unix.EPERM:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package fileio
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyFallbacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("recording"), 100000)
+	src := filepath.Join(dir, "src.ts")
+	ioutil.WriteFile(src, data, 0666)
+
+	origReflink, origRange, origSendfile := reflink, copyFileRange, sendfile
+	defer func() { reflink, copyFileRange, sendfile = origReflink, origRange, origSendfile }()
+	noReflink := func(dst, src *os.File) error { return unix.EOPNOTSUPP }
+	// As copy_file_range does on some filesystems, such as procfs.
+	copiesNothing := func(dst, src *os.File) (int, error) { return 0, nil }
+	noSendfile := func(dst, src *os.File) (int, error) { return 0, unix.EINVAL }
+
+	tests := []struct {
+		reflink       func(dst, src *os.File) error
+		copyFileRange func(dst, src *os.File) (int, error)
+		sendfile      func(dst, src *os.File) (int, error)
+		want          Method
+	}{
+		{noReflink, origRange, origSendfile, MethodCopyFileRange},
+		{noReflink, copiesNothing, origSendfile, MethodSendfile},
+		{noReflink, copiesNothing, noSendfile, MethodBuffered},
+	}
+	for i, tt := range tests {
+		reflink, copyFileRange, sendfile = tt.reflink, tt.copyFileRange, tt.sendfile
+		dst := filepath.Join(dir, "dst.ts")
+		method, err := Copy(context.Background(), src, dst)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if method != tt.want {
+			t.Errorf("%d: Expected %s, got %s", i, tt.want, method)
+		}
+		if buf, _ := ioutil.ReadFile(dst); !bytes.Equal(buf, data) {
+			t.Errorf("%d: Expected %d bytes copied, got %d", i, len(data), len(buf))
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fileio
+
+import (
+	"context"
+	"os"
+)
+
+func copyData(ctx context.Context, dst, src *os.File) (Method, error) {
+	return MethodBuffered, copyBuffered(ctx, dst, src)
+}
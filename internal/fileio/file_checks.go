@@ -1,6 +1,9 @@
 package fileio
 
-import "os"
+import (
+	"os"
+	"syscall"
+)
 
 func IsFile(f string) bool {
 	fi, err := os.Stat(f)
@@ -12,3 +15,28 @@ func IsFile(f string) bool {
 	}
 	return !fi.IsDir()
 }
+
+// FreeSpace is the number of bytes available to us on the filesystem holding path.
+func FreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// SameFilesystem reports whether a and b are on the same filesystem, so a
+// rename between them won't need to copy.
+func SameFilesystem(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	aDev, ok1 := fsnum(aInfo)
+	bDev, ok2 := fsnum(bInfo)
+	return ok1 && ok2 && aDev == bDev
+}
@@ -1,7 +1,9 @@
 package fileio
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"math/rand"
@@ -10,48 +12,190 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-func Copy(ctx context.Context, sourceFile, destFile string) error {
+// Method is how a file's data was copied.
+type Method string
+
+const (
+	MethodRename        Method = "rename"
+	MethodReflink       Method = "reflink"
+	MethodCopyFileRange Method = "copy_file_range"
+	MethodSendfile      Method = "sendfile"
+	MethodBuffered      Method = "buffered"
+)
+
+// Options control how Copy and Move copy files.
+type Options struct {
+	// Verify re-reads the copy and compares its checksum with the source's
+	// before Copy returns, and so before Move removes the source.
+	Verify bool
+}
+
+var DefaultOptions Options
+
+// Copy copies sourceFile to destFile, replacing it, with the source's
+// permissions, ownership (where allowed) and timestamps. The copy is synced
+// to disk along with its directory before Copy returns. A partial copy is
+// removed if copying fails or ctx is canceled.
+//
+// The fastest method the filesystems support is used, and returned.
+func Copy(ctx context.Context, sourceFile, destFile string) (method Method, err error) {
 	src, err := os.Open(sourceFile)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer src.Close()
-	dst, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY, 0666)
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+	dst, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(destFile)
+		}
+	}()
+
+	method, err = copyData(ctx, dst, src)
+	if err != nil {
+		return method, err
+	}
+	if err := copyMetadata(dst, info); err != nil {
+		return method, err
+	}
+	if err := dst.Sync(); err != nil {
+		return method, errors.Wrap(err, "sync copy")
+	}
+	if err := dst.Close(); err != nil {
+		return method, err
+	}
+	if err := syncDir(filepath.Dir(destFile)); err != nil {
+		return method, err
+	}
+	if DefaultOptions.Verify {
+		return method, verifyCopy(ctx, sourceFile, destFile)
+	}
+	return method, nil
+}
+
+// copyBuffered copies in chunks so a canceled ctx stops a long copy promptly.
+func copyBuffered(ctx context.Context, dst, src *os.File) error {
+	buf := make([]byte, 1024*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func copyMetadata(dst *os.File, info os.FileInfo) error {
+	if err := dst.Chmod(info.Mode().Perm()); err != nil {
+		return errors.Wrap(err, "copy permissions")
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Only root can give files away, so this is best effort.
+		if err := dst.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+			return errors.Wrap(err, "copy ownership")
+		}
+	}
+	if err := os.Chtimes(dst.Name(), time.Now(), info.ModTime()); err != nil {
+		return errors.Wrap(err, "copy timestamps")
+	}
+	return nil
+}
+
+func verifyCopy(ctx context.Context, sourceFile, destFile string) error {
+	want, err := checksum(ctx, sourceFile)
+	if err != nil {
+		return err
+	}
+	got, err := checksum(ctx, destFile)
 	if err != nil {
 		return err
 	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("copy of %s to %s is corrupt, checksums differ", sourceFile, destFile)
+	}
+	return nil
+}
+
+func checksum(ctx context.Context, fileName string) ([]byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
 	buf := make([]byte, 1024*1024)
-	defer dst.Close()
-	_, err = io.CopyBuffer(dst, src, buf)
-	return err
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			return h.Sum(nil), nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return errors.Wrap(err, "sync dir")
+	}
+	return nil
 }
 
-func Move(ctx context.Context, sourceFile, destFile string) error {
+// Move renames sourceFile to destFile, or copies it and removes the source
+// when they're on different filesystems. It returns how the data was moved.
+func Move(ctx context.Context, sourceFile, destFile string) (Method, error) {
 	sourceFileAbs, err := filepath.Abs(sourceFile)
 	if err != nil {
-		return errors.Wrap(err, "cannot abs")
+		return "", errors.Wrap(err, "cannot abs")
 	}
 	destFileAbs, err := filepath.Abs(destFile)
 	if err != nil {
-		return errors.Wrap(err, "cannot abs")
+		return "", errors.Wrap(err, "cannot abs")
 	}
 
 	if sourceFile == destFile {
-		return fmt.Errorf("cannot move, %s and %s are the same file", sourceFile, destFile)
+		return "", fmt.Errorf("cannot move, %s and %s are the same file", sourceFile, destFile)
 	}
 	fi, err := os.Stat(sourceFileAbs)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	destDir := filepath.Dir(destFileAbs)
 	destDirInfo, err := os.Stat(destDir)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	fsnumSrc, ok1 := fsnum(fi)
@@ -59,28 +203,33 @@ func Move(ctx context.Context, sourceFile, destFile string) error {
 	if ok1 && ok2 && fsnumSrc == fsnumDest {
 		err = os.Rename(sourceFileAbs, destFileAbs)
 		if err == nil {
-			return err
+			return MethodRename, nil
 		} else if !strings.Contains(err.Error(), "cross-device") {
-			return err
+			return "", err
 		}
 	}
 
 	addOnFile := destFileAbs + ".tmp." + strconv.FormatInt(rand.Int63(), 16)
 
-	if err := Copy(ctx, sourceFileAbs, addOnFile); err != nil {
-		return err
+	method, err := Copy(ctx, sourceFileAbs, addOnFile)
+	if err != nil {
+		return method, err
 	}
 	if err = os.Rename(addOnFile, destFileAbs); err != nil {
-		return err
+		os.Remove(addOnFile)
+		return method, err
+	}
+	if err := syncDir(destDir); err != nil {
+		return method, err
 	}
 
-	return os.Remove(sourceFile)
+	return method, os.Remove(sourceFile)
 }
 
 func fsnum(info os.FileInfo) (uint64, bool) {
 	ls, ok := info.Sys().(*syscall.Stat_t)
 	if ok {
-		return ls.Dev, true
+		return uint64(ls.Dev), true
 	}
 	return 0, false
 }
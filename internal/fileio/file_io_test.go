@@ -0,0 +1,66 @@
+package fileio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.ts")
+	dst := filepath.Join(dir, "dst.ts")
+	ioutil.WriteFile(src, []byte("short"), 0640)
+	mtime := time.Date(2020, 5, 1, 20, 0, 0, 0, time.UTC)
+	os.Chtimes(src, mtime, mtime)
+	ioutil.WriteFile(dst, []byte("a much longer existing file"), 0666)
+
+	DefaultOptions.Verify = true
+	defer func() { DefaultOptions.Verify = false }()
+	method, err := Copy(context.Background(), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("copied with %s", method)
+	if buf, _ := ioutil.ReadFile(dst); string(buf) != "short" {
+		t.Errorf("Expected existing destination to be replaced, got %q", buf)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %s, got %s", mtime, info.ModTime())
+	}
+}
+
+func TestCopyCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.ts")
+	dst := filepath.Join(dir, "dst.ts")
+	ioutil.WriteFile(src, make([]byte, 4<<20), 0666)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Copy(ctx, src, dst); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Expected partial copy to be removed, got %v", err)
+	}
+}
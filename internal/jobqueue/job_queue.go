@@ -0,0 +1,367 @@
+// Package jobqueue is a small on-disk queue of processing jobs.
+//
+// Each job is a JSON file in the queue directory, so the queue survives
+// restarts and can be shared by several processes. Changes happen under an
+// flock on the directory's lock file.
+package jobqueue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/crast/dvr-tools"
+	"github.com/crast/dvr-tools/internal/jsonio"
+)
+
+type State string
+
+const (
+	Pending State = "pending"
+	Running State = "running"
+	Failed  State = "failed"
+	Done    State = "done"
+	// Held jobs are waiting for someone to look at them, and are only run
+	// again once retried or approved.
+	Held State = "held"
+)
+
+type Job struct {
+	ID        int                  `json:"id"`
+	File      string               `json:"file"`
+	State     State                `json:"state"`
+	Overrides *videoproc.Overrides `json:"overrides,omitempty"`
+
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Result   string `json:"result,omitempty"`
+	// NotBefore delays a pending job, e.g. while backing off after a failure.
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	// PID is the process running the job.
+	PID int `json:"pid,omitempty"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+var ErrNotFound = errors.New("job not found")
+
+// ErrConflict is returned by Add when the file already has a job with other
+// overrides.
+var ErrConflict = errors.New("file already has a job with other overrides")
+
+type Queue struct {
+	Dir string
+}
+
+// Open creates the queue directory if needed and returns any jobs left
+// running by a process that has since died to the pending state.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create queue dir")
+	}
+	q := &Queue{Dir: dir}
+	return q, q.locked(func() error {
+		jobs, err := q.list()
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if job.State == Running && !processAlive(job.PID) {
+				job.State = Pending
+				job.PID = 0
+				if err := q.save(job); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Add queues fileName. If the file already has a job that is pending,
+// running, held or failed, that job is returned instead of adding a
+// duplicate, along with ErrConflict if its overrides differ.
+func (q *Queue) Add(fileName string, overrides *videoproc.Overrides) (job *Job, err error) {
+	err = q.locked(func() error {
+		jobs, err := q.list()
+		if err != nil {
+			return err
+		}
+		for _, existing := range jobs {
+			if existing.File == fileName && existing.State != Done {
+				job = existing
+				if !reflect.DeepEqual(existing.Overrides, overrides) {
+					return ErrConflict
+				}
+				return nil
+			}
+		}
+		id, err := q.nextID()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		job = &Job{ID: id, File: fileName, State: Pending, Overrides: overrides, Created: now}
+		return q.save(job)
+	})
+	return job, err
+}
+
+// List returns all jobs, oldest first.
+func (q *Queue) List() (jobs []*Job, err error) {
+	err = q.locked(func() error {
+		jobs, err = q.list()
+		return err
+	})
+	return jobs, err
+}
+
+func (q *Queue) Get(id int) (job *Job, err error) {
+	err = q.locked(func() error {
+		job, err = q.load(id)
+		return err
+	})
+	return job, err
+}
+
+// Claim marks the oldest pending job that is due at now as running by this
+// process and returns it. It returns nil if there's nothing to do.
+func (q *Queue) Claim(now time.Time) (job *Job, err error) {
+	err = q.locked(func() error {
+		jobs, err := q.list()
+		if err != nil {
+			return err
+		}
+		for _, candidate := range jobs {
+			if candidate.State == Pending && !candidate.NotBefore.After(now) {
+				job = candidate
+				job.State = Running
+				job.PID = os.Getpid()
+				job.Attempts++
+				return q.save(job)
+			}
+		}
+		return nil
+	})
+	return job, err
+}
+
+// Complete marks a job done, with a short note on the outcome.
+func (q *Queue) Complete(job *Job, result string) error {
+	return q.update(job.ID, func(j *Job) {
+		j.State = Done
+		j.Result = result
+		j.Error = ""
+		j.PID = 0
+		j.NotBefore = time.Time{}
+	})
+}
+
+// RetryAt puts a job back to pending, to be run no earlier than at. The
+// error that caused the retry, if any, is kept on the job.
+func (q *Queue) RetryAt(job *Job, at time.Time, cause error) error {
+	return q.update(job.ID, func(j *Job) {
+		j.State = Pending
+		j.PID = 0
+		j.NotBefore = at.UTC()
+		if cause != nil {
+			j.Error = cause.Error()
+		}
+	})
+}
+
+// Requeue returns a job that was interrupted, or couldn't start, to pending
+// without counting the attempt. It isn't claimed again before at.
+func (q *Queue) Requeue(job *Job, at time.Time) error {
+	return q.update(job.ID, func(j *Job) {
+		j.State = Pending
+		j.PID = 0
+		j.NotBefore = at.UTC()
+		if j.Attempts > 0 {
+			j.Attempts--
+		}
+	})
+}
+
+// Park marks a job as permanently failed with its error.
+func (q *Queue) Park(job *Job, cause error) error {
+	return q.update(job.ID, func(j *Job) {
+		j.State = Failed
+		j.PID = 0
+		j.Error = cause.Error()
+		j.NotBefore = time.Time{}
+	})
+}
+
+// Hold marks a job as held for review, with the reason.
+func (q *Queue) Hold(job *Job, cause error) error {
+	return q.update(job.ID, func(j *Job) {
+		j.State = Held
+		j.PID = 0
+		j.Error = cause.Error()
+		j.NotBefore = time.Time{}
+	})
+}
+
+// Approve releases a held job to run again, ignoring the commercial limits
+// that held it.
+func (q *Queue) Approve(id int) (*Job, error) {
+	var job *Job
+	err := q.update(id, func(j *Job) {
+		if j.State != Held {
+			return
+		}
+		if j.Overrides == nil {
+			j.Overrides = &videoproc.Overrides{}
+		}
+		j.Overrides.IgnoreLimits = true
+		j.State = Pending
+		j.Attempts = 0
+		job = j
+	})
+	if err == nil && job == nil {
+		err = errors.Errorf("job %d is not held", id)
+	}
+	return job, err
+}
+
+// Retry resets a failed or done job to pending and clears its attempt count.
+func (q *Queue) Retry(id int) (*Job, error) {
+	var job *Job
+	err := q.update(id, func(j *Job) {
+		if j.State == Running {
+			return
+		}
+		j.State = Pending
+		j.Attempts = 0
+		j.NotBefore = time.Time{}
+		job = j
+	})
+	if err == nil && job == nil {
+		err = errors.Errorf("job %d is running", id)
+	}
+	return job, err
+}
+
+// Remove deletes a job that is not currently running.
+func (q *Queue) Remove(id int) error {
+	return q.locked(func() error {
+		job, err := q.load(id)
+		if err != nil {
+			return err
+		}
+		if job.State == Running && processAlive(job.PID) {
+			return errors.Errorf("job %d is running", id)
+		}
+		os.Remove(q.LogFile(id))
+		return os.Remove(q.jobFile(id))
+	})
+}
+
+// LogFile is where the output of running a job is kept.
+func (q *Queue) LogFile(id int) string {
+	return filepath.Join(q.Dir, "job-"+strconv.Itoa(id)+".log")
+}
+
+func (q *Queue) update(id int, f func(*Job)) error {
+	return q.locked(func() error {
+		job, err := q.load(id)
+		if err != nil {
+			return err
+		}
+		f(job)
+		return q.save(job)
+	})
+}
+
+func (q *Queue) locked(f func() error) error {
+	lock, err := os.OpenFile(filepath.Join(q.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return errors.Wrap(err, "open queue lock")
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.Wrap(err, "lock queue")
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return f()
+}
+
+func (q *Queue) jobFile(id int) string {
+	return filepath.Join(q.Dir, "job-"+strconv.Itoa(id)+".json")
+}
+
+func (q *Queue) load(id int) (*Job, error) {
+	var job Job
+	if err := jsonio.ReadFile(q.jobFile(id), &job); err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// save writes the job to a temporary file and renames it into place, so a
+// crash never leaves a half written job behind.
+func (q *Queue) save(job *Job) error {
+	job.Updated = time.Now().UTC()
+	tmpFile := q.jobFile(job.ID) + ".tmp"
+	if err := jsonio.WriteFile(tmpFile, job); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, q.jobFile(job.ID))
+}
+
+func (q *Queue) list() ([]*Job, error) {
+	names, err := filepath.Glob(filepath.Join(q.Dir, "job-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, name := range names {
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), "job-"), ".json"))
+		if err != nil {
+			continue
+		}
+		job, err := q.load(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load %s", name)
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func (q *Queue) nextID() (int, error) {
+	counterFile := filepath.Join(q.Dir, "next-id")
+	id := 1
+	if buf, err := ioutil.ReadFile(counterFile); err == nil {
+		id, _ = strconv.Atoi(strings.TrimSpace(string(buf)))
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	if id < 1 {
+		id = 1
+	}
+	return id, ioutil.WriteFile(counterFile, []byte(strconv.Itoa(id+1)), 0666)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,122 @@
+package jobqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/crast/dvr-tools"
+)
+
+func TestLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := q.Add("/dvr/a.ts", nil)
+	b, _ := q.Add("/dvr/b.ts", nil)
+	if dup, _ := q.Add("/dvr/a.ts", nil); dup.ID != a.ID {
+		t.Errorf("Expected duplicate add to return job %d, got %d", a.ID, dup.ID)
+	}
+	if dup, err := q.Add("/dvr/a.ts", &videoproc.Overrides{Profile: "hd"}); err != ErrConflict || dup.ID != a.ID {
+		t.Errorf("Expected a conflict with job %d, got %+v %v", a.ID, dup, err)
+	}
+
+	now := time.Now()
+	claimed, err := q.Claim(now)
+	if err != nil || claimed == nil || claimed.ID != a.ID {
+		t.Fatalf("Expected to claim job %d, got %+v %v", a.ID, claimed, err)
+	}
+	if err := q.RetryAt(claimed, now.Add(time.Hour), errors.New("disk full")); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, _ = q.Claim(now)
+	if claimed == nil || claimed.ID != b.ID {
+		t.Fatalf("Expected backed off job to be passed over for %d, got %+v", b.ID, claimed)
+	}
+	// Found locked by another process, which doesn't use up an attempt.
+	q.Requeue(claimed, now.Add(time.Minute))
+	if claimed, _ = q.Claim(now); claimed != nil {
+		t.Fatalf("Expected requeued job to wait, got %+v", claimed)
+	}
+	claimed, _ = q.Claim(now.Add(time.Minute))
+	if claimed == nil || claimed.ID != b.ID || claimed.Attempts != 1 {
+		t.Fatalf("Expected first attempt of %d again, got %+v", b.ID, claimed)
+	}
+	q.Park(claimed, errors.New("bad file"))
+
+	if claimed, _ = q.Claim(now); claimed != nil {
+		t.Errorf("Expected nothing to claim, got %+v", claimed)
+	}
+	claimed, _ = q.Claim(now.Add(2 * time.Hour))
+	if claimed == nil || claimed.ID != a.ID || claimed.Attempts != 2 || claimed.Error != "disk full" {
+		t.Fatalf("Expected second attempt of %d, got %+v", a.ID, claimed)
+	}
+	q.Complete(claimed, "processed")
+
+	if _, err := q.Retry(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Remove(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := q.List()
+	if len(jobs) != 1 || jobs[0].ID != b.ID || jobs[0].State != Pending || jobs[0].Attempts != 0 {
+		t.Errorf("Unexpected jobs after retry and remove: %+v", jobs)
+	}
+}
+
+func TestOpenRecoversDeadRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, _ := Open(dir)
+	job, _ := q.Add("/dvr/a.ts", nil)
+	job.State = Running
+	job.PID = 1 << 30
+	q.save(job)
+
+	q, _ = Open(dir)
+	job, _ = q.Get(job.ID)
+	if job.State != Pending {
+		t.Errorf("Expected running job with dead pid to be pending, got %s", job.State)
+	}
+}
+
+func TestHoldApprove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, _ := Open(dir)
+	job, _ := q.Add("/dvr/a.ts", nil)
+	if _, err := q.Approve(job.ID); err == nil {
+		t.Error("Expected approving a job that isn't held to fail")
+	}
+	claimed, _ := q.Claim(time.Now())
+	q.Hold(claimed, errors.New("too many commercials"))
+	if claimed, _ = q.Claim(time.Now()); claimed != nil {
+		t.Errorf("Expected held job not to be claimed, got %+v", claimed)
+	}
+	approved, err := q.Approve(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.State != Pending || approved.Overrides == nil || !approved.Overrides.IgnoreLimits {
+		t.Errorf("Expected approved job to be pending and ignore limits, got %+v", approved)
+	}
+}
@@ -17,7 +17,7 @@ func ReadFile(filename string, v interface{}) error {
 }
 
 func WriteFile(filename string, v interface{}) error {
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
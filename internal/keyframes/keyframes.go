@@ -0,0 +1,163 @@
+// Package keyframes finds the keyframes in a video with ffprobe, and snaps
+// cut points to them so stream copies start cleanly.
+package keyframes
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/pkg/errors"
+)
+
+// Snapping policies for cut boundaries.
+const (
+	// None leaves cut points alone.
+	None = ""
+	// Nearest moves each boundary to the closest keyframe.
+	Nearest = "nearest"
+	// KeepMore moves each boundary outwards, so a kept range only grows.
+	KeepMore = "keep-more"
+	// KeepLess moves each boundary inwards, so a kept range only shrinks.
+	KeepLess = "keep-less"
+)
+
+// ValidPolicy reports whether policy is one of the snapping policies.
+func ValidPolicy(policy string) bool {
+	switch policy {
+	case None, Nearest, KeepMore, KeepLess:
+		return true
+	}
+	return false
+}
+
+// Index is the sorted times of a video's keyframes, in seconds.
+type Index []float64
+
+// Probe reads the keyframe times of fileName's first video stream.
+func Probe(ctx context.Context, fileName string) (Index, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags:format=start_time",
+		"-of", "csv",
+		fileName,
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get stdout pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "could not start ffprobe")
+	}
+	index, err := Parse(stdout)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, errors.Wrap(err, "ffprobe failed")
+	}
+	return index, nil
+}
+
+// Parse reads ffprobe's CSV "packet,pts_time,flags" and "format,start_time"
+// lines. It keeps the times of keyframe packets, made relative to the start
+// time as ffmpeg's -ss is.
+func Parse(r io.Reader) (Index, error) {
+	var index Index
+	var start float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		switch {
+		case len(fields) == 2 && fields[0] == "format":
+			start, _ = strconv.ParseFloat(fields[1], 64)
+		case len(fields) == 3 && fields[0] == "packet":
+			if !strings.Contains(fields[2], "K") || fields[1] == "N/A" {
+				continue
+			}
+			t, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad packet time %q", fields[1])
+			}
+			index = append(index, t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i := range index {
+		index[i] -= start
+	}
+	sort.Float64s(index)
+	return index, nil
+}
+
+// before returns the last keyframe at or before t.
+func (idx Index) before(t float64) (float64, bool) {
+	i := sort.SearchFloat64s(idx, t)
+	if i < len(idx) && idx[i] == t {
+		return t, true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return idx[i-1], true
+}
+
+// after returns the first keyframe at or after t.
+func (idx Index) after(t float64) (float64, bool) {
+	i := sort.SearchFloat64s(idx, t)
+	if i == len(idx) {
+		return 0, false
+	}
+	return idx[i], true
+}
+
+func (idx Index) nearest(t float64) (float64, bool) {
+	b, okb := idx.before(t)
+	a, oka := idx.after(t)
+	switch {
+	case okb && oka:
+		if t-b <= a-t {
+			return b, true
+		}
+		return a, true
+	case okb:
+		return b, true
+	default:
+		return a, oka
+	}
+}
+
+// Snap moves the boundaries of a kept range to keyframes by the policy.
+// A boundary with no keyframe to move to is left alone.
+func (idx Index) Snap(r cutlist.Range, policy string) cutlist.Range {
+	var begin, end func(float64) (float64, bool)
+	switch policy {
+	case Nearest:
+		begin, end = idx.nearest, idx.nearest
+	case KeepMore:
+		begin, end = idx.before, idx.after
+	case KeepLess:
+		begin, end = idx.after, idx.before
+	default:
+		return r
+	}
+	out := r
+	if t, ok := begin(r.Begin); ok {
+		out.Begin = t
+	}
+	if t, ok := end(r.End); ok {
+		out.End = t
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package keyframes
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+)
+
+const probeOutput = `packet,1.000000,K_
+packet,1.033367,__
+packet,3.002000,K_
+packet,3.035367,__
+packet,N/A,K_
+packet,7.006000,K_
+packet,5.004000,K_
+format,1.000000
+`
+
+func TestSnap(t *testing.T) {
+	idx, err := Parse(strings.NewReader(probeOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx) != 4 || math.Abs(idx[2]-4.004) > 1e-9 {
+		t.Fatalf("Expected 4 sorted keyframes, got %v", idx)
+	}
+
+	r := cutlist.Range{Begin: 2.5, End: 5.5}
+	cases := []struct {
+		policy string
+		want   cutlist.Range
+	}{
+		{None, r},
+		{Nearest, cutlist.Range{Begin: 2.002, End: 6.006}},
+		{KeepMore, cutlist.Range{Begin: 2.002, End: 6.006}},
+		{KeepLess, cutlist.Range{Begin: 4.004, End: 4.004}},
+	}
+	for _, c := range cases {
+		if got := idx.Snap(r, c.policy); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.policy, c.want, got)
+		}
+	}
+	if got := idx.Snap(cutlist.Range{Begin: 5, End: 9}, KeepMore); got.End != 9 {
+		t.Errorf("Expected an end past the last keyframe to stay, got %v", got)
+	}
+	if ValidPolicy("sideways") {
+		t.Error("Expected an unknown policy to be invalid")
+	}
+}
@@ -0,0 +1,103 @@
+// Package scratch gives each job its own working dir inside a scratch dir.
+//
+// Every job dir holds a manifest naming the process that owns it, so the
+// dirs of jobs that never got to clean up after themselves (killed, crashed)
+// can be found and removed by a later run.
+package scratch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/crast/dvr-tools/internal/jsonio"
+)
+
+const manifestName = "manifest.json"
+
+type Manifest struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	File    string    `json:"file"`
+	Started time.Time `json:"started"`
+}
+
+// jobDirPattern matches job dirs, and legacyPattern the loose files scratch
+// used to be filled with before there were job dirs: p<pid>_ parts,
+// p<pid>fpart.txt and comskip's vp<pid>.* output.
+var (
+	jobDirPattern = regexp.MustCompile(`^vp-(\d+)-\d+$`)
+	legacyPattern = regexp.MustCompile(`^(?:p(\d+)(?:_|fpart\.txt$)|vp(\d+)\.)`)
+	hostname, _   = os.Hostname()
+)
+
+// Create makes the dir for job id of this process in root and writes its
+// manifest. file is the media file the job is processing.
+func Create(root string, id int, file string) (string, error) {
+	dir := filepath.Join(root, fmt.Sprintf("vp-%d-%d", os.Getpid(), id))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", errors.Wrap(err, "create job scratch dir")
+	}
+	m := Manifest{PID: os.Getpid(), Host: hostname, File: file, Started: time.Now().UTC()}
+	if err := jsonio.WriteFile(filepath.Join(dir, manifestName), &m); err != nil {
+		return "", errors.Wrap(err, "write scratch manifest")
+	}
+	return dir, nil
+}
+
+// Sweep removes job dirs and legacy scratch files in root that belong to
+// processes on this host which are no longer running, once they haven't been
+// touched for olderThan. It returns what it removed.
+func Sweep(root string, olderThan time.Duration) ([]string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var removed []string
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		var pid int
+		if m := jobDirPattern.FindStringSubmatch(entry.Name()); m != nil && entry.IsDir() {
+			pid, _ = strconv.Atoi(m[1])
+			var manifest Manifest
+			if jsonio.ReadFile(filepath.Join(path, manifestName), &manifest) == nil && manifest.Host != hostname {
+				continue
+			}
+		} else if m := legacyPattern.FindStringSubmatch(entry.Name()); m != nil && !entry.IsDir() {
+			pid, _ = strconv.Atoi(m[1] + m[2])
+		} else {
+			continue
+		}
+		if !orphaned(pid, entry.ModTime(), olderThan) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func orphaned(pid int, modTime time.Time, olderThan time.Duration) bool {
+	if time.Since(modTime) < olderThan {
+		return false
+	}
+	if pid == os.Getpid() {
+		// Ours, or left by a dead process whose pid we reused. Either way
+		// a later run will take care of it.
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err != nil && err != syscall.EPERM
+}
@@ -0,0 +1,62 @@
+package scratch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	root, err := ioutil.TempDir("", "scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	live, err := Create(root, 1, "/dvr/a.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := filepath.Join(root, "vp-1073741824-1")
+	os.Mkdir(dead, 0777)
+	var legacy []string
+	for _, name := range []string{"p1073741824_3_tmp0.ts", "p1073741824fpart.txt", "vp1073741824.edl", "vp1073741824.log"} {
+		legacy = append(legacy, filepath.Join(root, name))
+		ioutil.WriteFile(legacy[len(legacy)-1], nil, 0666)
+	}
+	unrelated := filepath.Join(root, "vp1073741824notes")
+	ioutil.WriteFile(unrelated, nil, 0666)
+	fresh := filepath.Join(root, "vp-1073741825-1")
+	os.Mkdir(fresh, 0777)
+	other := filepath.Join(root, "queue")
+	os.Mkdir(other, 0777)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range append([]string{live, dead, unrelated, other}, legacy...) {
+		os.Chtimes(path, old, old)
+	}
+
+	removed, err := Sweep(root, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{dead: true}
+	for _, path := range legacy {
+		want[path] = true
+	}
+	if len(removed) != len(want) {
+		t.Errorf("Expected to sweep the dead job dir and legacy files, got %v", removed)
+	}
+	for _, path := range removed {
+		if !want[path] {
+			t.Errorf("Unexpectedly swept %s", path)
+		}
+	}
+	for _, path := range []string{live, fresh, other, unrelated} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package slots is a counting semaphore shared between processes.
+//
+// A directory holds one file per slot, and holding an flock on a slot file
+// holds the slot. Waiters queue up by creating ticket files, which are also
+// flock'd, so tickets and slots held by a process that died are freed by the
+// kernel rather than left behind.
+package slots
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrTimeout = errors.New("timed out waiting for a slot")
+
+// PollInterval is how often waiters check for a free slot.
+var PollInterval = 500 * time.Millisecond
+
+var ticketSerial int64
+
+type Semaphore struct {
+	Dir   string
+	Slots int
+}
+
+func New(dir string, slots int) (*Semaphore, error) {
+	if slots < 1 {
+		slots = 1
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create slot dir")
+	}
+	return &Semaphore{Dir: dir, Slots: slots}, nil
+}
+
+// Acquire waits its turn for a slot, first come first served. It returns
+// ErrTimeout if no slot was free within timeout, or zero to wait forever.
+// Call release to free the slot.
+func (s *Semaphore) Acquire(ctx context.Context, timeout time.Duration) (release func(), err error) {
+	ticket, err := s.takeTicket()
+	if err != nil {
+		return nil, err
+	}
+	defer ticket.drop()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		head, err := s.headTicket()
+		if err != nil {
+			return nil, err
+		}
+		if head == ticket.name {
+			if slot := s.trySlot(); slot != nil {
+				return func() { unlockFile(slot) }, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, ErrTimeout
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// Waiting is the number of processes queued for a slot.
+func (s *Semaphore) Waiting() int {
+	names, _ := s.tickets()
+	return len(names)
+}
+
+func (s *Semaphore) trySlot() *os.File {
+	for i := 0; i < s.Slots; i++ {
+		f, err := lockFile(filepath.Join(s.Dir, fmt.Sprintf("slot-%d", i)), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f
+		}
+	}
+	return nil
+}
+
+type ticket struct {
+	name string
+	file *os.File
+}
+
+func (t *ticket) drop() {
+	os.Remove(filepath.Join(filepath.Dir(t.file.Name()), t.name))
+	unlockFile(t.file)
+}
+
+// takeTicket joins the line. Ticket names sort in the order they were taken.
+// The ticket is locked before it's renamed into place, so nobody can mistake
+// it for a dead one.
+func (s *Semaphore) takeTicket() (*ticket, error) {
+	name := fmt.Sprintf("ticket-%020d-%d-%d", time.Now().UnixNano(), os.Getpid(), atomic.AddInt64(&ticketSerial, 1))
+	tmpPath := filepath.Join(s.Dir, ".new-"+name)
+	f, err := lockFile(tmpPath, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return nil, errors.Wrap(err, "take slot ticket")
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.Dir, name)); err != nil {
+		os.Remove(tmpPath)
+		unlockFile(f)
+		return nil, errors.Wrap(err, "take slot ticket")
+	}
+	return &ticket{name: name, file: f}, nil
+}
+
+// headTicket is the oldest ticket whose holder is still waiting. Tickets
+// nobody holds a lock on were left by a dead process and are removed.
+func (s *Semaphore) headTicket() (string, error) {
+	names, err := s.tickets()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		path := filepath.Join(s.Dir, name)
+		f, err := lockFile(path, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			return name, nil
+		}
+		os.Remove(path)
+		unlockFile(f)
+	}
+	return "", nil
+}
+
+func (s *Semaphore) tickets() ([]string, error) {
+	d, err := os.Open(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	all, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range all {
+		if strings.HasPrefix(name, "ticket-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func lockFile(path string, how int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
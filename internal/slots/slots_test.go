@@ -0,0 +1,81 @@
+package slots
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	release1, err := s.Acquire(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := s.Acquire(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Acquire(ctx, 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Expected ErrTimeout with all slots held, got %v", err)
+	}
+	if n := s.Waiting(); n != 0 {
+		t.Errorf("Expected timed out waiter to leave the line, %d still waiting", n)
+	}
+
+	got := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		i := i
+		go func() {
+			release, err := s.Acquire(ctx, 5*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got <- i
+			time.Sleep(20 * time.Millisecond)
+			release()
+		}()
+		// Make sure the waiters line up in order.
+		for s.Waiting() != i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	release1()
+	if first := <-got; first != 1 {
+		t.Errorf("Expected first waiter to get the free slot, got waiter %d", first)
+	}
+	release2()
+	<-got
+}
+
+func TestDeadTicket(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, _ := New(dir, 1)
+	// A ticket nobody holds a lock on, as if its process died while waiting.
+	ioutil.WriteFile(dir+"/ticket-00000000000000000001-1-1", nil, 0666)
+	release, err := s.Acquire(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("Expected dead ticket to be skipped, got %v", err)
+	}
+	release()
+}
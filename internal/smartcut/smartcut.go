@@ -0,0 +1,134 @@
+// Package smartcut plans frame accurate cuts that re-encode only the partial
+// GOPs at each cut point and stream copy the rest.
+package smartcut
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/keyframes"
+	"github.com/pkg/errors"
+)
+
+// Part is a piece of a kept range, either stream copied or re-encoded.
+type Part struct {
+	cutlist.Range
+	Encode bool
+}
+
+// Plan splits each kept range at the first and last keyframes inside it.
+// The part between them is copied, and the partial GOPs before and after are
+// re-encoded. A range with no whole GOP in it is re-encoded entirely.
+func Plan(kept []cutlist.Range, idx keyframes.Index) []Part {
+	var parts []Part
+	add := func(begin, end float64, encode bool) {
+		if end > begin {
+			parts = append(parts, Part{cutlist.Range{Begin: begin, End: end}, encode})
+		}
+	}
+	for _, r := range kept {
+		first, last := -1.0, -1.0
+		for _, k := range idx {
+			if k < r.Begin {
+				continue
+			}
+			if k > r.End {
+				break
+			}
+			if first < 0 {
+				first = k
+			}
+			last = k
+		}
+		if first < 0 || last <= first {
+			add(r.Begin, r.End, true)
+			continue
+		}
+		add(r.Begin, first, true)
+		add(first, last, false)
+		add(last, r.End, true)
+	}
+	return parts
+}
+
+// Video is what's needed of a video stream to encode parts that can be
+// joined to copies of it.
+type Video struct {
+	Codec      string `json:"codec_name"`
+	Profile    string `json:"profile"`
+	Level      int    `json:"level"`
+	PixFmt     string `json:"pix_fmt"`
+	FieldOrder string `json:"field_order"`
+	BitRate    string `json:"bit_rate"`
+}
+
+// ProbeVideo reads fileName's first video stream with ffprobe.
+func ProbeVideo(ctx context.Context, fileName string) (*Video, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_streams",
+		"-of", "json",
+		fileName,
+	).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "ffprobe failed")
+	}
+	var probe struct {
+		Streams []Video `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, errors.Wrap(err, "could not decode JSON")
+	}
+	if len(probe.Streams) == 0 {
+		return nil, errors.New("no video stream")
+	}
+	return &probe.Streams[0], nil
+}
+
+var encoders = map[string]string{
+	"h264":       "libx264",
+	"hevc":       "libx265",
+	"mpeg2video": "mpeg2video",
+}
+
+var profiles = map[string]string{
+	"Baseline":             "baseline",
+	"Constrained Baseline": "baseline",
+	"Main":                 "main",
+	"High":                 "high",
+	"Main 10":              "main10",
+}
+
+// EncoderArgs returns ffmpeg video encoder options that match v, so
+// re-encoded parts can be joined with stream copies of it.
+func EncoderArgs(v *Video) ([]string, error) {
+	encoder, ok := encoders[v.Codec]
+	if !ok {
+		return nil, fmt.Errorf("can't smart cut %s video", v.Codec)
+	}
+	args := []string{"-c:v", encoder}
+	if p, ok := profiles[v.Profile]; ok && encoder != "mpeg2video" {
+		args = append(args, "-profile:v", p)
+	}
+	if v.Level > 0 && encoder == "libx264" {
+		args = append(args, "-level", fmt.Sprintf("%d.%d", v.Level/10, v.Level%10))
+	}
+	if v.PixFmt != "" {
+		args = append(args, "-pix_fmt", v.PixFmt)
+	}
+	if rate, err := strconv.ParseInt(v.BitRate, 10, 64); err == nil && rate > 0 {
+		args = append(args, "-b:v", v.BitRate, "-maxrate", strconv.FormatInt(rate*3/2, 10), "-bufsize", strconv.FormatInt(rate*2, 10))
+	}
+	switch v.FieldOrder {
+	case "tt", "tb":
+		args = append(args, "-flags", "+ilme+ildct", "-top", "1")
+	case "bb", "bt":
+		args = append(args, "-flags", "+ilme+ildct", "-top", "0")
+	}
+	return args, nil
+}
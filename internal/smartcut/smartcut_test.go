@@ -0,0 +1,40 @@
+package smartcut
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/crast/dvr-tools/internal/cutlist"
+	"github.com/crast/dvr-tools/internal/keyframes"
+)
+
+func TestPlan(t *testing.T) {
+	idx := keyframes.Index{0, 2, 4, 6, 8, 10, 12}
+	kept := []cutlist.Range{{Begin: 0, End: 5}, {Begin: 6.5, End: 7.5}, {Begin: 9, End: 13}}
+	got := Plan(kept, idx)
+	want := []Part{
+		{cutlist.Range{Begin: 0, End: 4}, false},
+		{cutlist.Range{Begin: 4, End: 5}, true},
+		{cutlist.Range{Begin: 6.5, End: 7.5}, true},
+		{cutlist.Range{Begin: 9, End: 10}, true},
+		{cutlist.Range{Begin: 10, End: 12}, false},
+		{cutlist.Range{Begin: 12, End: 13}, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestEncoderArgs(t *testing.T) {
+	args, err := EncoderArgs(&Video{Codec: "h264", Profile: "High", Level: 40, PixFmt: "yuv420p", FieldOrder: "tt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-c:v", "libx264", "-profile:v", "high", "-level", "4.0", "-pix_fmt", "yuv420p", "-flags", "+ilme+ildct", "-top", "1"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Expected %v, got %v", want, args)
+	}
+	if _, err := EncoderArgs(&Video{Codec: "vc1"}); err == nil {
+		t.Error("Expected an unknown codec to fail")
+	}
+}
@@ -69,3 +69,29 @@ func ParseMKV(s string) (Offset, error) {
 	}
 	return Offset(seconds + float64(minutes*60) + float64(hours*3600)), nil
 }
+
+// Parse reads a time written as seconds, M:SS or H:MM:SS, with optional
+// fractional seconds.
+func Parse(s string) (Offset, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	var total float64
+	for i, part := range parts {
+		var v float64
+		var err error
+		if i == len(parts)-1 {
+			v, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			v = float64(n)
+		}
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		total = total*60 + v
+	}
+	return Offset(total), nil
+}
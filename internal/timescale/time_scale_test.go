@@ -33,3 +33,24 @@ func TestMKV(t *testing.T) {
 		})
 	}
 }
+
+func TestParse(t *testing.T) {
+	tests := map[string]Offset{
+		"45":         45,
+		"0:45":       45,
+		"12:30.5":    750.5,
+		"1:02:03":    3723,
+		"01:06:30.5": 3990.5,
+	}
+	for input, expect := range tests {
+		output, err := Parse(input)
+		if err != nil || output != expect {
+			t.Errorf("Parse(%q): Expected %f, got %f (%v)", input, float64(expect), float64(output), err)
+		}
+	}
+	for _, input := range []string{"", "1:2:3:4", "a:30", "-5"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q): Expected an error", input)
+		}
+	}
+}
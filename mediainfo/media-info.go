@@ -64,8 +64,9 @@ type VideoTrack struct {
 
 	Width            QuotedInt `json:"Width"`
 	Height           QuotedInt `json:"Height"`
-	PixelAspectRatio string    `json:"PixelAspectRatio"`
-	ScanType         string    `json:"ScanType"`
+	PixelAspectRatio string      `json:"PixelAspectRatio"`
+	ScanType         string      `json:"ScanType"`
+	FrameRate        QuotedFloat `json:"FrameRate"`
 }
 
 type AudioTrack struct {
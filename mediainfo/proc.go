@@ -3,7 +3,6 @@ package mediainfo
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"os/exec"
 
 	"github.com/pkg/errors"
@@ -23,7 +22,7 @@ func Parse(ctx context.Context, filename string) (*MediaInfo, error) {
 		return nil, errors.Wrap(err, "could not decode JSON")
 	}
 	if err = cmd.Wait(); err != nil {
-		log.Fatal(err)
+		return nil, errors.Wrap(err, "mediainfo failed")
 	}
 	stdout.Close()
 	return &mediaInfo, nil